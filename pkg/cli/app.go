@@ -5,14 +5,40 @@ import (
 	"time"
 
 	"github.com/urfave/cli/v2"
+
+	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
 )
 
 var (
-	notifyFlags = []cli.Flag{
+	modelFlags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "model",
+			Usage: "Model pattern of devices to notify such as \"Google*\". Overrides models.include in config.json",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude-model",
+			Usage: "Model pattern of devices not to notify. Overrides models.exclude in config.json",
+		},
+		&cli.BoolFlag{
+			Name:  "any-model",
+			Usage: "Notify any cast-capable receivers except excluded models",
+		},
+	}
+
+	notifyFlags = joinFlags([]cli.Flag{
 		&cli.StringFlag{
 			Name:  "device-name",
 			Usage: "Target Google Home device name. Default notify from all found devices",
 		},
+		&cli.StringFlag{
+			Name:    "group",
+			Aliases: []string{"g"},
+			Usage:   "Target device group name defined in config.json",
+		},
+		&cli.StringFlag{
+			Name:  "cast-group",
+			Usage: "Target Cast speaker group name created in Google Home app. All speakers in the group play in sync",
+		},
 		&cli.IntFlag{
 			Name:  "device-count",
 			Value: 4,
@@ -27,17 +53,91 @@ var (
 		&cli.StringFlag{
 			Name:  "path",
 			Value: "",
-			Usage: "a Directory path name of credential files (credentials.json, tokens.json)",
+			Usage: "a Directory path name of credential files (credentials.json, tokens.json) and config.json",
+		},
+		&cli.StringFlag{
+			Name:  "tts",
+			Value: googlecast.TTSGoogleTranslate,
+			Usage: "Text-to-speech provider name. Overrides tts.provider in config.json",
+		},
+		&cli.StringFlag{
+			Name:  "voice",
+			Usage: "Voice name of the text-to-speech provider. Overrides tts.voice in config.json",
+		},
+		&cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "Declare a device as name=host[:port] to use it without mDNS discovery, in addition to devices.json",
+		},
+		&cli.BoolFlag{
+			Name:  "no-discovery",
+			Usage: "Do not discover devices by mDNS when no declared device matches",
+		},
+		&cli.IntFlag{
+			Name:  "volume",
+			Usage: "Volume percentage (1-100) during announcements. The previous volume is restored afterwards. Default keeps the current volume",
+		},
+		&cli.StringFlag{
+			Name:  "chime",
+			Usage: "Sound played before messages: \"builtin\", an audio file path, an URL or \"none\". Overrides chime.sound in config.json",
+		},
+		&cli.StringFlag{
+			Name:  "priority",
+			Usage: "Priority of notifications such as \"high\", which selects a chime of chime.priorities in config.json",
+		},
+		&cli.BoolFlag{
+			Name:  "no-resume",
+			Usage: "Do not resume media which was playing before announcements",
+		},
+		&cli.BoolFlag{
+			Name:  "wait",
+			Usage: "Wait until devices finish playing, and report final states. Default returns after devices accepted media",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: googlecast.DefaultParallelism,
+			Usage: "Maximum number of devices which are notified concurrently",
+		},
+		&cli.IntFlag{
+			Name:  "retry",
+			Usage: "Maximum number of attempts to connect and load media on each device. Overrides retry.max_attempts in config.json",
+		},
+		&cli.StringFlag{
+			Name:  "media-host",
+			Usage: "Advertised host address of the media server for cast devices. Default detects the LAN address",
+		},
+	}, modelFlags)
+
+	mediaPortFlags = []cli.Flag{
+		&cli.IntFlag{
+			Name:  "media-port",
+			Value: 0,
+			Usage: "Port of the media server which hosts generated audio and files. Default uses a random port",
 		},
 	}
 
-	serverFlags = []cli.Flag{
+	serverFlags = joinFlags([]cli.Flag{
 		&cli.IntFlag{
 			Name:    "port",
 			Aliases: []string{"p"},
 			Value:   8000,
 		},
-	}
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "Listen address such as \"127.0.0.1\", \"127.0.0.1:8000\" or \"unix:/run/notify.sock\". Default listens on all interfaces on --port. Overrides server.listen in config.json",
+		},
+		&cli.StringFlag{
+			Name:  "tls-cert",
+			Usage: "TLS certificate file. Updated files are reloaded without restart. Overrides server.tls.cert in config.json",
+		},
+		&cli.StringFlag{
+			Name:  "tls-key",
+			Usage: "TLS private key file. Overrides server.tls.key in config.json",
+		},
+		&cli.StringFlag{
+			Name:  "tls-client-ca",
+			Usage: "CA certificate file which verifies client certificates for mutual TLS. Overrides server.tls.client_ca in config.json",
+		},
+	}, mediaPortFlags)
 )
 
 func App() *cli.App {
@@ -47,8 +147,7 @@ func App() *cli.App {
 				Name:    "daemon",
 				Aliases: []string{"d"},
 				Usage:   "Start daemon (run server and check calendars regularly)",
-				Flags: append(
-					append(notifyFlags, serverFlags...),
+				Flags: joinFlags(notifyFlags, serverFlags, []cli.Flag{
 					&cli.DurationFlag{
 						Name:    "notify-duration",
 						Aliases: []string{"n"},
@@ -61,7 +160,21 @@ func App() *cli.App {
 						Value:   time.Hour * 2,
 						Usage:   "Fetch plans within target duration from Google Calendars",
 					},
-				),
+					&cli.DurationFlag{
+						Name:  "discovery-interval",
+						Value: time.Minute * 5,
+						Usage: "Interval between background mDNS discoveries",
+					},
+					&cli.DurationFlag{
+						Name:  "discovery-ttl",
+						Value: googlecast.DefaultDiscoveryTTL,
+						Usage: "Lifetime of discovered devices. Expired devices are discovered again on notify",
+					},
+					&cli.BoolFlag{
+						Name:  "discovery-cache",
+						Usage: "Save discovered devices to discovered.json, and reuse them after restart",
+					},
+				}),
 				Action: startDaemon,
 			},
 			{
@@ -111,22 +224,73 @@ func App() *cli.App {
 					},
 				},
 			},
+			{
+				Name:   "devices",
+				Usage:  "List discovered devices",
+				Action: listDevices,
+				Flags: []cli.Flag{
+					&cli.IntFlag{
+						Name:  "device-count",
+						Value: 0,
+						Usage: "Maximum number of listed devices. 0 lists all devices",
+					},
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Value:   "table",
+						Usage:   "Output format (table, json)",
+					},
+				},
+			},
+			{
+				Name:   "discover",
+				Usage:  "Discover devices by mDNS and add them to devices.json",
+				Action: discoverDevices,
+				Flags: joinFlags([]cli.Flag{
+					&cli.IntFlag{
+						Name:  "device-count",
+						Value: 4,
+						Usage: "Maximum number of detected Google Home devices",
+					},
+					&cli.StringFlag{
+						Name:    "path",
+						Aliases: []string{"p"},
+						Value:   "",
+						Usage:   "a Directory path name of devices.json and config.json",
+					},
+				}, modelFlags),
+			},
 			{
 				Name:  "notify",
 				Usage: "Notify a message",
-				Flags: append(notifyFlags,
+				Flags: joinFlags(notifyFlags, []cli.Flag{
 					&cli.StringFlag{
 						Name:    "message",
 						Aliases: []string{"m"},
 						Value:   "Hello, world!!",
 					},
-				),
+				}, mediaPortFlags),
 				Action: notifyFromDevices,
 			},
+			{
+				Name:  "play",
+				Usage: "Play audio of an URL or a file",
+				Flags: joinFlags(notifyFlags, []cli.Flag{
+					&cli.StringFlag{
+						Name:  "url",
+						Usage: "http(s) URL of audio",
+					},
+					&cli.StringFlag{
+						Name:  "file",
+						Usage: "Audio file path, which is served by the media server",
+					},
+				}, mediaPortFlags),
+				Action: playFromDevices,
+			},
 			{
 				Name:   "server",
 				Usage:  "Run server",
-				Flags:  serverFlags,
+				Flags:  joinFlags(notifyFlags, serverFlags),
 				Action: simpleServe,
 			},
 		},
@@ -136,3 +300,12 @@ func App() *cli.App {
 	sort.Sort(cli.CommandsByName(app.Commands))
 	return app
 }
+
+// joinFlags concatenates flag groups into a new slice, so that commands do not share a backing array
+func joinFlags(groups ...[]cli.Flag) []cli.Flag {
+	flags := []cli.Flag{}
+	for _, group := range groups {
+		flags = append(flags, group...)
+	}
+	return flags
+}
@@ -2,21 +2,28 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/urfave/cli/v2"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/tomoyamachi/notifyhome/pkg/config"
 	"github.com/tomoyamachi/notifyhome/pkg/gcal"
 	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
 	"github.com/tomoyamachi/notifyhome/pkg/locale"
+	"github.com/tomoyamachi/notifyhome/pkg/media"
+	"github.com/tomoyamachi/notifyhome/pkg/multierr"
 	"github.com/tomoyamachi/notifyhome/pkg/server"
 )
 
@@ -31,26 +38,148 @@ func fetchAndShowPlans(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	eventsList, errs := getEventsAndEror(clis, c.Int64("count"), c.Duration("within"))
+	eventsList, err := gcal.FetchAllEvents(clis, c.Int64("count"), c.Duration("within"))
 	for idx, events := range eventsList {
 		for _, event := range events {
 			fmt.Printf("%d: %v %s\n", idx, event.Start, event.Title)
 		}
 	}
-	return checkErrs(errs)
+	return err
+}
+
+// devices Action
+func listDevices(c *cli.Context) error {
+	devices := googlecast.ListDevices(c.Context, c.Int("device-count"), true)
+	switch c.String("format") {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(devices)
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tMODEL\tIP\tPORT\tUUID\tCONNECTED")
+		for _, d := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%t\n", d.Name, d.Model, d.IP, d.Port, d.UUID, d.Connected)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown format: %s", c.String("format"))
+}
+
+// discover Action
+func discoverDevices(c *cli.Context) error {
+	conf, err := config.Load(c.String("path"))
+	if err != nil {
+		return err
+	}
+	filter, err := modelFilter(c, conf.Models)
+	if err != nil {
+		return err
+	}
+	discovered := googlecast.Discover(c.Context, c.Int("device-count"), filter)
+	if len(discovered) == 0 {
+		return errors.New("no device found")
+	}
+	registry, err := googlecast.LoadRegistry(c.String("path"))
+	if err != nil {
+		return err
+	}
+	for _, device := range discovered {
+		fmt.Printf("%s=%s:%d\n", device.Name, device.Host, device.Port)
+	}
+	return registry.Merge(discovered).Save(c.String("path"))
 }
 
 // notify Action
 func notifyFromDevices(c *cli.Context) error {
-	return googlecast.Notify(c.Context, c.Int("device-count"), c.String("device-name"), c.String("locale"), []string{c.String("message")})
+	return notifyWithMedia(c, func(opts googlecast.Options) (googlecast.Result, error) {
+		return googlecast.Notify(c.Context, opts, []string{c.String("message")})
+	})
+}
+
+// play Action
+func playFromDevices(c *cli.Context) error {
+	name := c.String("url")
+	if c.IsSet("file") == c.IsSet("url") {
+		return errors.New("either --url or --file is required")
+	}
+	if c.IsSet("file") {
+		name = c.String("file")
+	}
+	audio, err := googlecast.LoadAudio(name)
+	if err != nil {
+		return err
+	}
+	return notifyWithMedia(c, func(opts googlecast.Options) (googlecast.Result, error) {
+		return googlecast.PlayAudio(c.Context, opts, audio)
+	})
+}
+
+// notifyWithMedia calls notify with options of flags, and prints the result.
+// It serves generated audio and files on a dedicated listener while notifying.
+func notifyWithMedia(c *cli.Context, notify func(opts googlecast.Options) (googlecast.Result, error)) error {
+	conf, err := config.Load(c.String("path"))
+	if err != nil {
+		return err
+	}
+	opts, err := notifyOptions(c, conf)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", c.Int("media-port")))
+	if err != nil {
+		return fmt.Errorf("listen media server: %w", err)
+	}
+	defer ln.Close()
+	if mediaServer := newMediaServer(c, ln.Addr().(*net.TCPAddr).Port); mediaServer != nil {
+		defer mediaServer.Close()
+		go func() {
+			if err := http.Serve(ln, mediaServer); err != nil {
+				log.Printf("media server: %+v\n", err)
+			}
+		}()
+		opts.Media = mediaServer
+	}
+	result, err := notify(opts)
+	if printErr := printResult(result); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// printResult prints a result of each device as a table
+func printResult(result googlecast.Result) error {
+	if len(result.Devices) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tRESULT\tSTATE\tLATENCY\tDURATION\tERROR")
+	for _, d := range result.Devices {
+		status, errMsg := "ok", ""
+		if d.Err != nil {
+			status, errMsg = "failed", d.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.DeviceName, status, d.State, d.Latency.Round(time.Millisecond), d.Duration.Round(time.Millisecond), errMsg)
+	}
+	return w.Flush()
 }
 
 // server Action
 func simpleServe(c *cli.Context) error {
-	deviceCnt := c.Int("device-count")
-	deviceName := c.String("device-name")
-
-	return server.Run(c.Context, deviceCnt, deviceName, "ja", c.Int("port"))
+	conf, err := config.Load(c.String("path"))
+	if err != nil {
+		return err
+	}
+	opts, err := notifyOptions(c, conf)
+	if err != nil {
+		return err
+	}
+	srvConf := serverConfig(c, conf.Server)
+	mediaServer, err := hostMedia(c.Context, c, srvConf, &opts)
+	if err != nil {
+		return err
+	}
+	return server.Run(c.Context, opts, mediaServer, srvConf)
 }
 
 // daemon Action
@@ -66,24 +195,55 @@ func startDaemon(c *cli.Context) error {
 		cancel()
 	}()
 
+	conf, err := config.Load(c.String("path"))
+	if err != nil {
+		return err
+	}
+	opts, err := notifyOptions(c, conf)
+	if err != nil {
+		return err
+	}
+	calendarOpts, err := calendarOptions(opts, conf.Calendar)
+	if err != nil {
+		return err
+	}
 	eg, ctx := errgroup.WithContext(ctx)
-	localeCode := c.String("locale")
+	srvConf := serverConfig(c, conf.Server)
+	mediaServer, err := hostMedia(ctx, c, srvConf, &opts)
+	if err != nil {
+		return err
+	}
 	credentialPath := c.String("path")
-	deviceCnt := c.Int("device-count")
-	deviceName := c.String("device-name")
+	if !opts.NoDiscovery {
+		discoveryOpts := googlecast.DiscoveryOptions{
+			Interval: c.Duration("discovery-interval"),
+			TTL:      c.Duration("discovery-ttl"),
+		}
+		if c.Bool("discovery-cache") {
+			discoveryOpts.Path = credentialPath
+		}
+		eg.Go(func() error {
+			googlecast.RunDiscovery(ctx, discoveryOpts)
+			return nil
+		})
+	}
 	eg.Go(func() error {
-		return regularNotify(ctx, deviceCnt, deviceName, localeCode, credentialPath, c.Duration("notify-duration"), c.Duration("within"))
+		return regularNotify(ctx, calendarOpts, conf.Calendar, credentialPath, c.Duration("notify-duration"), c.Duration("within"))
 	})
 	eg.Go(func() error {
-		return server.Run(ctx, deviceCnt, deviceName, localeCode, c.Int("port"))
+		return server.Run(ctx, opts, mediaServer, srvConf)
 	})
 
 	return eg.Wait()
 }
 
-func regularNotify(ctx context.Context, deviceCnt int, deviceName, localeCode, credentialPath string, tick, within time.Duration) error {
-	if err := fetchAndNotifyPlans(ctx, deviceCnt, deviceName, localeCode, credentialPath, within); err != nil {
-		return err
+func regularNotify(ctx context.Context, opts googlecast.Options, calendar config.Calendar, credentialPath string, tick, within time.Duration) error {
+	// errors of accounts and devices are retried on the next tick, and only fatal errors stop the daemon
+	if err := fetchAndNotifyPlans(ctx, opts, calendar, credentialPath, within); err != nil {
+		if _, ok := err.(multierr.Errors); !ok {
+			return err
+		}
+		logErrs(err)
 	}
 	ticker := time.NewTicker(tick)
 	defer ticker.Stop()
@@ -91,8 +251,8 @@ func regularNotify(ctx context.Context, deviceCnt int, deviceName, localeCode, c
 		select {
 		case <-ticker.C:
 			log.Print("fetch plans and send notifications")
-			if err := fetchAndNotifyPlans(ctx, deviceCnt, deviceName, localeCode, credentialPath, within); err != nil {
-				log.Print(err)
+			if err := fetchAndNotifyPlans(ctx, opts, calendar, credentialPath, within); err != nil {
+				logErrs(err)
 			}
 		case <-ctx.Done():
 			return nil
@@ -100,72 +260,297 @@ func regularNotify(ctx context.Context, deviceCnt int, deviceName, localeCode, c
 	}
 }
 
-func fetchAndNotifyPlans(ctx context.Context, deviceCnt int, deviceName, localeCode, credentialPath string, within time.Duration) error {
+// fetchAndNotifyPlans notifies upcoming events to target groups of calendar rules concurrently.
+// The error is multierr.Errors which sources are calendar accounts and devices, or a fatal error if no account is available.
+func fetchAndNotifyPlans(ctx context.Context, opts googlecast.Options, calendar config.Calendar, credentialPath string, within time.Duration) error {
 	clis, err := gcal.GetClients(ctx, credentialPath)
 	if err != nil {
 		return err
 	}
-	eventsList, errs := getEventsAndEror(clis, 1, within)
-	locale := locale.GetLocale(localeCode)
+	eventsList, fetchErr := gcal.FetchAllEvents(clis, 1, within)
+	locale := locale.GetLocale(opts.Locale)
 
-	eventMsgs := []string{}
+	groupMsgs := map[string][]string{}
 	for _, events := range eventsList {
 		for _, event := range events {
 			if event.Start.After(time.Now()) {
-				eventMsgs = append(eventMsgs, locale.NotifyMessage(event.Start, event.Title))
+				group := calendar.GroupOf(event.Title)
+				if group == "" {
+					group = opts.Group
+				}
+				groupMsgs[group] = append(groupMsgs[group], locale.NotifyMessage(event.Start, event.Title))
 			}
 		}
 	}
-	if len(eventMsgs) == 0 {
+	if len(groupMsgs) == 0 {
 		log.Println("no messages")
-		return nil
-	}
-	if err := googlecast.Notify(ctx, deviceCnt, deviceName, locale.Code(), eventMsgs); err != nil {
-		errs = append(errs, err)
+		return fetchErr
 	}
-	return checkErrs(errs)
-}
-
-func getEventsAndEror(clis []*http.Client, cnt int64, within time.Duration) ([][]*gcal.Event, []error) {
-	eventsCh := make(chan []*gcal.Event, len(clis))
-	errChan := make(chan error, len(clis))
+	opts.Locale = locale.Code()
+	errs := make([]error, 0, len(groupMsgs))
+	var mu sync.Mutex
 	var wg sync.WaitGroup
-	wg.Add(len(clis))
-	for _, cli := range clis {
-		go func(cli *http.Client, wg *sync.WaitGroup) {
+	for group, msgs := range groupMsgs {
+		wg.Add(1)
+		go func(group string, msgs []string) {
 			defer wg.Done()
-			events, err := gcal.FetchEvents(cli, cnt, within)
-			if err != nil {
-				errChan <- err
-				return
+			groupOpts := opts
+			groupOpts.Group = group
+			if _, err := googlecast.Notify(ctx, groupOpts, msgs); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
 			}
-			if len(events) > 0 {
-				eventsCh <- events
-			}
-		}(cli, &wg)
+		}(group, msgs)
 	}
 	wg.Wait()
-	close(eventsCh)
-	close(errChan)
+	return multierr.Append(fetchErr, errs...)
+}
+
+// logErrs logs each error of aggregated errors
+func logErrs(err error) {
+	for _, e := range multierr.Split(err) {
+		log.Print(e)
+	}
+}
+
+// notifyOptions builds delivery settings from flags and config.json. Flags have priority over the config.
+func notifyOptions(c *cli.Context, conf *config.Config) (googlecast.Options, error) {
+	providerName := conf.TTS.Provider
+	if c.IsSet("tts") || providerName == "" {
+		providerName = c.String("tts")
+	}
+	voice := conf.TTS.Voice
+	if c.IsSet("voice") {
+		voice = c.String("voice")
+	}
+	provider, err := newTTSProvider(providerName, conf.TTS)
+	if err != nil {
+		return googlecast.Options{}, err
+	}
+	registry, err := googlecast.LoadRegistry(c.String("path"))
+	if err != nil {
+		return googlecast.Options{}, err
+	}
+	filter, err := modelFilter(c, conf.Models)
+	if err != nil {
+		return googlecast.Options{}, err
+	}
+	var volume float64
+	if c.IsSet("volume") {
+		if volume, err = volumeLevel(c.Int("volume")); err != nil {
+			return googlecast.Options{}, err
+		}
+	}
+	chimes, err := loadChimes(c, conf.Chime)
+	if err != nil {
+		return googlecast.Options{}, err
+	}
+	for _, s := range c.StringSlice("device") {
+		device, err := googlecast.ParseStaticDevice(s)
+		if err != nil {
+			return googlecast.Options{}, err
+		}
+		registry = append(registry, device)
+	}
+	return googlecast.Options{
+		DeviceCount:  c.Int("device-count"),
+		FriendlyName: c.String("device-name"),
+		Locale:       c.String("locale"),
+		Voice:        voice,
+		Volume:       volume,
+		NoResume:     c.Bool("no-resume"),
+		Priority:     c.String("priority"),
+		Chimes:       chimes,
+		TTS:          provider,
+		Devices:      registry,
+		NoDiscovery:  c.Bool("no-discovery"),
+		Models:       filter,
+		CastGroup:    c.String("cast-group"),
+		Group:        c.String("group"),
+		Groups:       conf.Groups,
+		Parallelism:  c.Int("parallel"),
+		Wait:         c.Bool("wait"),
+		Retry:        retryPolicy(c, conf.Retry),
+	}, nil
+}
+
+// loadChimes loads chimes of priorities from flags and config.json. Flags have priority over the config.
+func loadChimes(c *cli.Context, conf config.Chime) (map[string]*googlecast.Audio, error) {
+	sound := conf.Sound
+	if c.IsSet("chime") {
+		sound = c.String("chime")
+	}
+	names := map[string]string{"": sound}
+	for priority, name := range conf.Priorities {
+		names[priority] = name
+	}
+	chimes := make(map[string]*googlecast.Audio, len(names))
+	for priority, name := range names {
+		chime, err := googlecast.LoadChime(name)
+		if err != nil {
+			return nil, err
+		}
+		chimes[priority] = chime
+	}
+	return chimes, nil
+}
+
+// calendarOptions overrides options of notifications for calendar events by the calendar config
+func calendarOptions(opts googlecast.Options, calendar config.Calendar) (googlecast.Options, error) {
+	if calendar.Priority != "" {
+		opts.Priority = calendar.Priority
+	}
+	if calendar.Volume != 0 {
+		volume, err := volumeLevel(calendar.Volume)
+		if err != nil {
+			return opts, fmt.Errorf("calendar: %w", err)
+		}
+		opts.Volume = volume
+	}
+	return opts, nil
+}
+
+// volumeLevel converts a volume percentage to a level between 0.01 and 1
+func volumeLevel(percent int) (float64, error) {
+	if percent < 1 || percent > 100 {
+		return 0, fmt.Errorf("volume must be between 1 and 100: %d", percent)
+	}
+	return float64(percent) / 100, nil
+}
+
+// serverConfig builds settings of the notification server from flags and config.json
+func serverConfig(c *cli.Context, conf config.Server) server.Config {
+	keys := make([]server.Key, 0, len(conf.Keys))
+	for _, key := range conf.Keys {
+		scopes := make([]server.Scope, 0, len(key.Scopes))
+		for _, scope := range key.Scopes {
+			scopes = append(scopes, server.Scope(scope))
+		}
+		keys = append(keys, server.Key{Name: key.Name, Token: key.Token, Secret: key.Secret, Scopes: scopes})
+	}
+	srvConf := server.Config{
+		Port:   c.Int("port"),
+		Listen: conf.Listen,
+		TLS:    server.TLSConfig{CertFile: conf.TLS.Cert, KeyFile: conf.TLS.Key, ClientCAFile: conf.TLS.ClientCA},
+		Keys:   keys,
+		RateLimit: server.RateLimit{
+			Client:          server.Rate{Requests: conf.RateLimit.Client.Requests, Per: time.Duration(conf.RateLimit.Client.Per)},
+			Global:          server.Rate{Requests: conf.RateLimit.Global.Requests, Per: time.Duration(conf.RateLimit.Global.Per)},
+			DuplicateWindow: time.Duration(conf.RateLimit.DuplicateWindow),
+			MaxQueue:        conf.RateLimit.MaxQueue,
+		},
+	}
+	if c.IsSet("listen") {
+		srvConf.Listen = c.String("listen")
+	}
+	if c.IsSet("tls-cert") {
+		srvConf.TLS.CertFile = c.String("tls-cert")
+	}
+	if c.IsSet("tls-key") {
+		srvConf.TLS.KeyFile = c.String("tls-key")
+	}
+	if c.IsSet("tls-client-ca") {
+		srvConf.TLS.ClientCAFile = c.String("tls-client-ca")
+	}
+	return srvConf
+}
 
-	eventsList := make([][]*gcal.Event, len(clis))
-	for event := range eventsCh {
-		eventsList = append(eventsList, event)
+// retryPolicy builds a retry policy from flags and config.json. Flags have priority over the config.
+func retryPolicy(c *cli.Context, conf config.Retry) googlecast.RetryPolicy {
+	policy := googlecast.RetryPolicy{
+		MaxAttempts:    conf.MaxAttempts,
+		InitialBackoff: time.Duration(conf.InitialBackoff),
+		MaxBackoff:     time.Duration(conf.MaxBackoff),
+		Jitter:         conf.Jitter,
+		AttemptTimeout: time.Duration(conf.AttemptTimeout),
+	}
+	if c.IsSet("retry") {
+		policy.MaxAttempts = c.Int("retry")
+	}
+	return policy
+}
+
+// modelFilter builds a model filter from flags and config.json. Flags have priority over the config.
+func modelFilter(c *cli.Context, conf config.Models) (googlecast.ModelFilter, error) {
+	filter := googlecast.ModelFilter{Include: conf.Include, Exclude: conf.Exclude, Any: conf.Any}
+	if c.IsSet("model") {
+		filter.Include = c.StringSlice("model")
+	}
+	if c.IsSet("exclude-model") {
+		filter.Exclude = c.StringSlice("exclude-model")
 	}
+	if c.IsSet("any-model") {
+		filter.Any = c.Bool("any-model")
+	}
+	return filter, filter.Validate()
+}
 
-	errs := []error{}
-	for err := range errChan {
-		errs = append(errs, err)
+// hostMedia hosts generated audio and files for devices until ctx is done, and returns a media server to mount on the notification server.
+// Devices can not fetch files from the notification server behind TLS, a Unix domain socket or a loopback address,
+// so files are served on a dedicated plain HTTP listener of --media-port instead, and nil is returned.
+func hostMedia(ctx context.Context, c *cli.Context, srvConf server.Config, opts *googlecast.Options) (*media.Server, error) {
+	if port, ok := srvConf.MediaPort(); ok {
+		mediaServer := newMediaServer(c, port)
+		if mediaServer != nil {
+			go mediaServer.Run(ctx)
+			opts.Media = mediaServer
+		}
+		return mediaServer, nil
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", c.Int("media-port")))
+	if err != nil {
+		return nil, fmt.Errorf("listen media server: %w", err)
 	}
-	return eventsList, errs
+	mediaServer := newMediaServer(c, ln.Addr().(*net.TCPAddr).Port)
+	if mediaServer == nil {
+		ln.Close()
+		return nil, nil
+	}
+	go mediaServer.Run(ctx)
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	go func() {
+		if err := http.Serve(ln, mediaServer); err != nil && ctx.Err() == nil {
+			log.Printf("media server: %+v\n", err)
+		}
+	}()
+	log.Printf("media server start on: %s\n", ln.Addr())
+	opts.Media = mediaServer
+	return nil, nil
 }
 
-func checkErrs(errs []error) (err error) {
-	if len(errs) == 0 {
+// newMediaServer returns a media server advertised on the port.
+// It returns nil if the LAN address is unknown, since only local TTS providers need it.
+func newMediaServer(c *cli.Context, port int) *media.Server {
+	baseURL, err := media.BaseURL(c.String("media-host"), port)
+	if err != nil {
+		log.Printf("media server is disabled: %+v\n", err)
 		return nil
 	}
-	for _, err = range errs {
-		log.Print(err)
+	mediaServer, err := media.NewServer(baseURL, media.DefaultTTL)
+	if err != nil {
+		log.Printf("media server is disabled: %+v\n", err)
+		return nil
+	}
+	return mediaServer
+}
+
+func newTTSProvider(name string, conf config.TTS) (googlecast.TTSProvider, error) {
+	switch name {
+	case "", googlecast.TTSGoogleTranslate:
+		return googlecast.TranslateTTS{}, nil
+	case googlecast.TTSEspeak:
+		return googlecast.NewEspeakTTS(), nil
+	case googlecast.TTSPico2Wave:
+		return googlecast.NewPico2WaveTTS(), nil
+	case googlecast.TTSCommand:
+		if len(conf.Command) == 0 {
+			return nil, fmt.Errorf("tts.command is required for %s provider", name)
+		}
+		return &googlecast.CommandTTS{Command: conf.Command, ContentType: conf.ContentType}, nil
 	}
-	return err // temporary, return a last error
+	return nil, fmt.Errorf("unknown tts provider: %s", name)
 }
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const configFile = "config.json"
+
+type (
+	// Config is settings of the daemon loaded from config.json
+	Config struct {
+		TTS    TTS    `json:"tts"`
+		Models Models `json:"models"`
+		// Groups maps group names to device names
+		Groups   map[string][]string `json:"groups"`
+		Calendar Calendar            `json:"calendar"`
+		Retry    Retry               `json:"retry"`
+		Chime    Chime               `json:"chime"`
+		Server   Server              `json:"server"`
+	}
+
+	// Server is settings of the notification server
+	Server struct {
+		// Keys are API keys of clients. The server does not authenticate requests if no key is defined.
+		Keys []APIKey `json:"keys"`
+		// Listen is a listen address such as "127.0.0.1:8000" or "unix:/run/notify.sock". Empty listens on all interfaces
+		Listen    string    `json:"listen"`
+		TLS       ServerTLS `json:"tls"`
+		RateLimit RateLimit `json:"rate_limit"`
+	}
+
+	// RateLimit is limits of notification requests. Zero values disable each limit.
+	RateLimit struct {
+		// Client limits requests of each API key, or each remote address without keys
+		Client Rate `json:"client"`
+		// Global limits requests of all clients
+		Global Rate `json:"global"`
+		// DuplicateWindow suppresses the same message to the same targets within the duration
+		DuplicateWindow Duration `json:"duplicate_window"`
+		// MaxQueue is a maximum number of waiting announcements on each device
+		MaxQueue int `json:"max_queue"`
+	}
+
+	// Rate is a number of requests per a duration
+	Rate struct {
+		Requests int      `json:"requests"`
+		Per      Duration `json:"per"`
+	}
+
+	// ServerTLS is certificate files of the notification server
+	ServerTLS struct {
+		Cert string `json:"cert"`
+		Key  string `json:"key"`
+		// ClientCA verifies client certificates for mutual TLS
+		ClientCA string `json:"client_ca"`
+	}
+
+	// APIKey is a named key of a client of the notification server
+	APIKey struct {
+		Name string `json:"name"`
+		// Token is sent as a bearer token or an X-API-Key header
+		Token string `json:"token"`
+		// Secret signs request bodies by HMAC-SHA256 for webhook senders
+		Secret string `json:"secret"`
+		// Scopes are allowed operations: "notify", "quiet" or "admin"
+		Scopes []string `json:"scopes"`
+	}
+
+	// Chime is sounds played before messages
+	Chime struct {
+		// Sound is a default chime: "builtin", an audio file path, or an URL. Empty plays no chime
+		Sound string `json:"sound"`
+		// Priorities maps notification priorities to chimes. "none" plays no chime for the priority
+		Priorities map[string]string `json:"priorities"`
+	}
+
+	// TTS is settings of a text-to-speech provider
+	TTS struct {
+		Provider string `json:"provider"`
+		Voice    string `json:"voice"`
+		// Command is a command line template for the "command" provider
+		Command     []string `json:"command"`
+		ContentType string   `json:"content_type"`
+	}
+
+	// Models is patterns of device models to notify
+	Models struct {
+		Include []string `json:"include"`
+		Exclude []string `json:"exclude"`
+		Any     bool     `json:"any"`
+	}
+
+	// Retry is a retry policy of playback on devices. Zero values use defaults.
+	Retry struct {
+		MaxAttempts    int      `json:"max_attempts"`
+		InitialBackoff Duration `json:"initial_backoff"`
+		MaxBackoff     Duration `json:"max_backoff"`
+		Jitter         float64  `json:"jitter"`
+		AttemptTimeout Duration `json:"attempt_timeout"`
+	}
+
+	// Duration is a duration formatted as "1s" or "500ms" in JSON
+	Duration time.Duration
+
+	// Calendar is target groups of calendar reminders
+	Calendar struct {
+		// Group is a default target group. Empty notifies the default devices
+		Group string         `json:"group"`
+		Rules []CalendarRule `json:"rules"`
+		// Volume is a volume percentage of reminders between 1 and 100. Zero uses the volume option of the daemon
+		Volume int `json:"volume"`
+		// Priority is a priority of reminders, which selects a chime
+		Priority string `json:"priority"`
+	}
+
+	// CalendarRule notifies events which title contains the keyword to the group
+	CalendarRule struct {
+		Keyword string `json:"keyword"`
+		Group   string `json:"group"`
+	}
+)
+
+// Load config from file. Returns empty config if the file does not exist.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path + configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &Config{}, nil
+		}
+		return nil, fmt.Errorf("Open %s: %w", path+configFile, err)
+	}
+	defer f.Close()
+	var conf Config
+	if err = json.NewDecoder(f).Decode(&conf); err != nil {
+		return nil, fmt.Errorf("Decode config: %w", err)
+	}
+	return &conf, nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// GroupOf returns a target group of the event title. The first matched rule has priority.
+func (c Calendar) GroupOf(title string) string {
+	title = strings.ToLower(title)
+	for _, rule := range c.Rules {
+		if strings.Contains(title, strings.ToLower(rule.Keyword)) {
+			return rule.Group
+		}
+	}
+	return c.Group
+}
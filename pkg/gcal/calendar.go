@@ -4,12 +4,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
+
+	"github.com/tomoyamachi/notifyhome/pkg/multierr"
 )
 
 const (
@@ -57,6 +60,33 @@ func GetClients(ctx context.Context, credentialPath string) ([]*http.Client, err
 	return clis, nil
 }
 
+// Account returns a name of the calendar account at the index of tokens.json
+func Account(idx int) string {
+	return fmt.Sprintf("calendar account %d", idx+1)
+}
+
+// FetchAllEvents fetches events of all accounts concurrently. Events of an account are at the same index as the client.
+// The error is multierr.Errors which sources are accounts, and events of succeeded accounts are returned as well.
+func FetchAllEvents(clis []*http.Client, max int64, duration time.Duration) ([][]*Event, error) {
+	eventsList := make([][]*Event, len(clis))
+	errs := make([]error, len(clis))
+	var wg sync.WaitGroup
+	wg.Add(len(clis))
+	for idx, cli := range clis {
+		go func(idx int, cli *http.Client) {
+			defer wg.Done()
+			eventsList[idx], errs[idx] = FetchEvents(cli, max, duration)
+		}(idx, cli)
+	}
+	wg.Wait()
+
+	var err error
+	for idx, e := range errs {
+		err = multierr.Append(err, multierr.Wrap(Account(idx), e))
+	}
+	return eventsList, err
+}
+
 func FetchEvents(cli *http.Client, max int64, duration time.Duration) ([]*Event, error) {
 	events, err := fetchFromGoogle(cli, max, duration)
 	if err != nil {
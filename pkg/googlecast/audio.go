@@ -0,0 +1,71 @@
+package googlecast
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// audioTypes are content types of audio extensions, which are not registered on some systems
+var audioTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".flac": "audio/flac",
+	".webm": "audio/webm",
+}
+
+// LoadAudio loads audio from an http(s) URL or a file path. The content type is detected from the data or the extension.
+func LoadAudio(name string) (*Audio, error) {
+	if strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://") {
+		u, err := url.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("parse audio url: %w", err)
+		}
+		return &Audio{URL: u, ContentType: DetectContentType(u.Path, nil)}, nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read audio: %w", err)
+	}
+	return &Audio{Data: data, ContentType: DetectContentType(name, data)}, nil
+}
+
+// DetectContentType returns a content type of audio by sniffing the data, or by the extension of the name.
+// It returns "audio/mpeg" if both are unknown.
+func DetectContentType(name string, data []byte) string {
+	if len(data) > 0 {
+		switch sniffed := http.DetectContentType(data); {
+		case sniffed == "application/ogg":
+			return "audio/ogg"
+		case sniffed == "audio/wave":
+			return "audio/wav"
+		case strings.HasPrefix(sniffed, "audio/"):
+			return sniffed
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if contentType, ok := audioTypes[ext]; ok {
+		return contentType
+	}
+	if contentType := mime.TypeByExtension(ext); strings.HasPrefix(contentType, "audio/") {
+		return contentType
+	}
+	return "audio/mpeg"
+}
+
+// String returns a description of the audio
+func (a *Audio) String() string {
+	if a.URL != nil {
+		return a.URL.String()
+	}
+	return fmt.Sprintf("%s (%d bytes)", a.ContentType, len(a.Data))
+}
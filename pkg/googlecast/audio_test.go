@@ -0,0 +1,25 @@
+package googlecast
+
+import "testing"
+
+func TestDetectContentType(t *testing.T) {
+	tests := map[string]struct {
+		name string
+		data []byte
+		want string
+	}{
+		"wav data":          {name: "bell", data: builtinChime(), want: "audio/wav"},
+		"mp3 data":          {name: "bell.bin", data: []byte("ID3\x03\x00\x00\x00"), want: "audio/mpeg"},
+		"ogg data":          {name: "bell", data: []byte("OggS\x00\x02"), want: "audio/ogg"},
+		"unknown data":      {name: "bell.m4a", data: []byte("unknown"), want: "audio/mp4"},
+		"extension":         {name: "/sounds/bell.FLAC", want: "audio/flac"},
+		"unknown extension": {name: "/translate_tts", want: "audio/mpeg"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := DetectContentType(tt.name, tt.data); got != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package googlecast
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/mdns"
+)
+
+const (
+	discoveredFile = "discovered.json"
+
+	// DefaultDiscoveryTTL is a lifetime of cached devices
+	DefaultDiscoveryTTL      = 15 * time.Minute
+	defaultDiscoveryInterval = 5 * time.Minute
+)
+
+type (
+	// DiscoveryOptions is settings of background discovery
+	DiscoveryOptions struct {
+		// Interval is an interval between discoveries
+		Interval time.Duration
+		// TTL is a lifetime of cached devices
+		TTL time.Duration
+		// Path is a directory to save discovered devices. Empty does not save them.
+		Path string
+	}
+
+	// deviceCache keeps discovered devices. Devices connect on their first playback, and keep the connections.
+	deviceCache struct {
+		// refreshMu prevents concurrent mDNS queries
+		refreshMu sync.Mutex
+
+		mu      sync.Mutex
+		devices map[string]*CastDevice
+		updated time.Time
+		ttl     time.Duration
+	}
+)
+
+var discovered = &deviceCache{devices: map[string]*CastDevice{}, ttl: DefaultDiscoveryTTL}
+
+// RunDiscovery discovers devices regularly, and caches them until ctx is done.
+// Notify uses cached devices without waiting for mDNS discovery.
+func RunDiscovery(ctx context.Context, opts DiscoveryOptions) {
+	if opts.TTL > 0 {
+		discovered.setTTL(opts.TTL)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultDiscoveryInterval
+	}
+	defer discovered.clear()
+	if opts.Path != "" {
+		discovered.load(opts.Path + discoveredFile)
+	}
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+	for {
+		if devices := discovered.refresh(); len(devices) > 0 && opts.Path != "" {
+			if err := discovered.registry().write(opts.Path + discoveredFile); err != nil {
+				log.Printf("save discovered devices: %+v\n", err)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cachedDevices returns cached target devices, or discovers devices if the cache is expired
+func cachedDevices(max int, names []string, filter ModelFilter) []*CastDevice {
+	match := func(device *CastDevice) bool { return device.isTarget(names, filter) }
+	if devices, fresh := discovered.find(max, match); fresh {
+		return devices
+	}
+	discovered.refreshIfExpired()
+	devices, _ := discovered.find(max, match)
+	return devices
+}
+
+// find returns cached devices which match, and whether the cache is fresh
+func (c *deviceCache) find(max int, match func(device *CastDevice) bool) ([]*CastDevice, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	devices := []*CastDevice{}
+	for _, device := range c.devices {
+		if match(device) {
+			devices = append(devices, device)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID() < devices[j].ID() })
+	if max > 0 && len(devices) > max {
+		devices = devices[:max]
+	}
+	fresh := len(c.devices) > 0 && time.Since(c.updated) < c.ttl
+	return devices, fresh
+}
+
+func (c *deviceCache) refreshIfExpired() {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	// another caller may have refreshed while waiting
+	c.mu.Lock()
+	fresh := len(c.devices) > 0 && time.Since(c.updated) < c.ttl
+	c.mu.Unlock()
+	if !fresh {
+		c.refreshLocked()
+	}
+}
+
+// refresh discovers devices of any models and speaker groups by mDNS, and reuses known devices to keep their connections.
+// Targets are selected on find.
+func (c *deviceCache) refresh() []*CastDevice {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.refreshLocked()
+}
+
+func (c *deviceCache) refreshLocked() []*CastDevice {
+	devices := lookup(func(entry *mdns.ServiceEntry) *CastDevice {
+		device := &CastDevice{ServiceEntry: entry}
+		if cached := c.get(device.ID()); cached != nil && cached.Name() == device.Name() &&
+			cached.AddrV4.Equal(entry.AddrV4) && cached.Port == entry.Port {
+			return cached
+		}
+		return device
+	})
+	// keeps cached devices when discovery fails temporarily
+	if len(devices) > 0 {
+		c.replace(devices)
+	}
+	return devices
+}
+
+// load caches devices saved by previous discoveries. They are connected on the first playback.
+func (c *deviceCache) load(fileName string) {
+	r, err := readRegistry(fileName)
+	if err != nil {
+		log.Printf("load discovered devices: %+v\n", err)
+		return
+	}
+	if devices := r.resolve(func(StaticDevice) bool { return true }); len(devices) > 0 {
+		c.replace(devices)
+	}
+}
+
+// idOf returns an advertised UUID of a cached device on the address, or empty if no device is cached
+func (c *deviceCache) idOf(ip net.IP, port int) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, device := range c.devices {
+		if device.ServiceEntry != nil && device.AddrV4.Equal(ip) && device.Port == port {
+			return device.info(idPrefix)
+		}
+	}
+	return ""
+}
+
+func (c *deviceCache) get(id string) *CastDevice {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.devices[id]
+}
+
+// replace replaces cached devices, and closes devices which are no longer cached
+func (c *deviceCache) replace(devices []*CastDevice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := make(map[string]*CastDevice, len(devices))
+	for _, device := range devices {
+		next[device.ID()] = device
+	}
+	for id, old := range c.devices {
+		if next[id] != old {
+			old.Close()
+		}
+	}
+	c.devices = next
+	c.updated = time.Now()
+}
+
+// invalidate removes a device which failed, so that it is connected again on next lookup
+func (c *deviceCache) invalidate(device *CastDevice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.devices[device.ID()] != device {
+		return
+	}
+	device.Close()
+	delete(c.devices, device.ID())
+}
+
+func (c *deviceCache) registry() Registry {
+	devices, _ := c.find(0, anyDevice)
+	r := make(Registry, 0, len(devices))
+	for _, device := range devices {
+		r = append(r, device.staticDevice())
+	}
+	return r
+}
+
+func (c *deviceCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttl = ttl
+}
+
+func (c *deviceCache) clear() {
+	c.replace(nil)
+}
@@ -0,0 +1,127 @@
+package googlecast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/barnybug/go-cast/api"
+	"github.com/barnybug/go-cast/controllers"
+	castnet "github.com/barnybug/go-cast/net"
+)
+
+const receiverNamespace = "urn:x-cast:com.google.cast.receiver"
+
+// Response types of namespaces. True is a failure of the request.
+var (
+	receiverResponses = map[string]bool{
+		"RECEIVER_STATUS": false,
+		"LAUNCH_ERROR":    true,
+		"INVALID_REQUEST": true,
+	}
+	mediaResponses = map[string]bool{
+		"MEDIA_STATUS":         false,
+		"LOAD_FAILED":          true,
+		"LOAD_CANCELLED":       true,
+		"INVALID_PLAYER_STATE": true,
+		"INVALID_REQUEST":      true,
+	}
+)
+
+// castChannel sends requests to a namespace of a device, and waits for their responses.
+// go-cast controllers track requests without locks while the receive loop dispatches responses,
+// so requests are tracked here instead, and only listeners of go-cast channels are used.
+type castChannel struct {
+	channel   *castnet.Channel
+	responses map[string]bool
+
+	mu      sync.Mutex
+	lastID  int
+	pending map[int]chan *api.CastMessage
+}
+
+// newCastChannel listens to the response types on the channel.
+// The channel should be opened before sending anything on the connection,
+// since go-cast does not guard channels of a connection against its receive loop.
+func newCastChannel(channel *castnet.Channel, responses map[string]bool) *castChannel {
+	c := &castChannel{channel: channel, responses: responses, pending: map[int]chan *api.CastMessage{}}
+	for typ := range responses {
+		channel.OnMessage(typ, c.dispatch)
+	}
+	return c
+}
+
+// request sends a request of the type, and returns the response. payload builds a request from its headers,
+// or the headers are sent as is if payload is nil.
+func (c *castChannel) request(ctx context.Context, typ string, payload func(headers castnet.PayloadHeaders) interface{}) (*api.CastMessage, error) {
+	c.mu.Lock()
+	c.lastID++
+	id := c.lastID
+	response := make(chan *api.CastMessage, 1)
+	c.pending[id] = response
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	headers := castnet.PayloadHeaders{Type: typ, RequestId: &id}
+	var body interface{} = &headers
+	if payload != nil {
+		body = payload(headers)
+	}
+	if err := c.channel.Send(body); err != nil {
+		return nil, fmt.Errorf("send %s: %w", typ, err)
+	}
+	select {
+	case message := <-response:
+		var resp castnet.PayloadHeaders
+		if err := json.Unmarshal([]byte(message.GetPayloadUtf8()), &resp); err != nil {
+			return nil, fmt.Errorf("unmarshal response of %s: %w", typ, err)
+		}
+		if c.responses[resp.Type] {
+			return nil, fmt.Errorf("%s is rejected: %s", typ, resp.Type)
+		}
+		return message, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for response of %s: %w", typ, ctx.Err())
+	}
+}
+
+// dispatch passes a response to the request which waits for it. It runs on the receive loop of the connection.
+func (c *castChannel) dispatch(message *api.CastMessage) {
+	var headers castnet.PayloadHeaders
+	if err := json.Unmarshal([]byte(message.GetPayloadUtf8()), &headers); err != nil || headers.RequestId == nil {
+		return
+	}
+	c.mu.Lock()
+	response, ok := c.pending[*headers.RequestId]
+	delete(c.pending, *headers.RequestId)
+	c.mu.Unlock()
+	if ok {
+		response <- message
+	}
+}
+
+// receiverStatus returns status of the receiver
+func receiverStatus(ctx context.Context, rec *castChannel) (*controllers.ReceiverStatus, error) {
+	message, err := rec.request(ctx, "GET_STATUS", nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseReceiverStatus(message)
+}
+
+func parseReceiverStatus(message *api.CastMessage) (*controllers.ReceiverStatus, error) {
+	var resp controllers.StatusResponse
+	if err := json.Unmarshal([]byte(message.GetPayloadUtf8()), &resp); err != nil {
+		return nil, fmt.Errorf("unmarshal receiver status: %w", err)
+	}
+	if resp.Status == nil {
+		return nil, errors.New("receiver status is empty")
+	}
+	return resp.Status, nil
+}
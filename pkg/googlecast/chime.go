@@ -0,0 +1,76 @@
+package googlecast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+const (
+	// ChimeBuiltin is a chime name of the built-in two-tone chime
+	ChimeBuiltin = "builtin"
+	// ChimeNone is a chime name which disables a chime
+	ChimeNone = "none"
+
+	chimeSampleRate = 22050
+)
+
+// LoadChime loads a sound played before messages. name is ChimeBuiltin, an http(s) URL, or an audio file path.
+// It returns nil for an empty name or ChimeNone.
+func LoadChime(name string) (*Audio, error) {
+	switch name {
+	case "", ChimeNone:
+		return nil, nil
+	case ChimeBuiltin:
+		return &Audio{Data: builtinChime(), ContentType: "audio/wav"}, nil
+	}
+	chime, err := LoadAudio(name)
+	if err != nil {
+		return nil, fmt.Errorf("load chime: %w", err)
+	}
+	return chime, nil
+}
+
+// chime returns a chime of the priority of the notification, or the default chime
+func (o Options) chime() *Audio {
+	if chime, ok := o.Chimes[o.Priority]; ok {
+		return chime
+	}
+	return o.Chimes[""]
+}
+
+// builtinChime generates a WAV of two decaying tones
+func builtinChime() []byte {
+	tones := []struct {
+		freq     float64
+		duration float64
+	}{
+		{freq: 880, duration: 0.25},    // A5
+		{freq: 1318.51, duration: 0.6}, // E6
+	}
+	samples := []int16{}
+	for _, tone := range tones {
+		n := int(tone.duration * chimeSampleRate)
+		for i := 0; i < n; i++ {
+			t := float64(i) / chimeSampleRate
+			envelope := math.Exp(-4 * t / tone.duration)
+			samples = append(samples, int16(0.5*math.MaxInt16*envelope*math.Sin(2*math.Pi*tone.freq*t)))
+		}
+	}
+
+	var buf bytes.Buffer
+	dataSize := uint32(len(samples) * 2)
+	header := []interface{}{
+		[]byte("RIFF"), 36 + dataSize, []byte("WAVE"),
+		// fmt chunk of 16bit mono PCM
+		[]byte("fmt "), uint32(16), uint16(1), uint16(1), uint32(chimeSampleRate), uint32(chimeSampleRate * 2), uint16(2), uint16(16),
+		[]byte("data"), dataSize,
+	}
+	for _, v := range header {
+		// writes to a buffer never fail
+		_ = binary.Write(&buf, binary.LittleEndian, v)
+	}
+	_ = binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
@@ -0,0 +1,90 @@
+package googlecast
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadChime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "bell.ogg")
+	if err := ioutil.WriteFile(file, []byte("not sniffed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	builtin, err := LoadChime(ChimeBuiltin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := builtin.Data
+	if builtin.ContentType != "audio/wav" || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		t.Errorf("builtin chime is not a wav: %s %q", builtin.ContentType, data[:12])
+	}
+	if size := binary.LittleEndian.Uint32(data[40:44]); int(size) != len(data)-44 {
+		t.Errorf("want data size %d, got %d", len(data)-44, size)
+	}
+
+	fromFile, err := LoadChime(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(fromFile.Data) != "not sniffed" || fromFile.ContentType != "audio/ogg" {
+		t.Errorf("unexpected chime from file: %+v", fromFile)
+	}
+	fromURL, err := LoadChime("https://example.com/bell.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fromURL.URL.String() != "https://example.com/bell.mp3" || fromURL.ContentType != "audio/mpeg" {
+		t.Errorf("unexpected chime from url: %+v", fromURL)
+	}
+	if none, err := LoadChime(ChimeNone); none != nil || err != nil {
+		t.Errorf("want no chime, got %+v %v", none, err)
+	}
+	if _, err := LoadChime(filepath.Join(dir, "missing.mp3")); err == nil {
+		t.Error("want error of missing file")
+	}
+}
+
+func TestSpeakWithChime(t *testing.T) {
+	chimes := map[string]*Audio{
+		"":     {Data: []byte("default"), ContentType: "audio/wav"},
+		"high": {Data: []byte("alarm"), ContentType: "audio/mp3"},
+		"low":  nil,
+	}
+	tests := map[string]struct {
+		priority string
+		want     []string
+	}{
+		"default":              {priority: "", want: []string{"default|audio/wav", "RIFF|audio/wav"}},
+		"high":                 {priority: "high", want: []string{"alarm|audio/mp3", "RIFF|audio/wav"}},
+		"no chime":             {priority: "low", want: []string{"RIFF|audio/wav"}},
+		"unknown uses default": {priority: "urgent", want: []string{"default|audio/wav", "RIFF|audio/wav"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			media := &fakeMediaHost{}
+			provider := &fakeTTS{audio: &Audio{Data: []byte("RIFF"), ContentType: "audio/wav"}}
+			opts := Options{TTS: provider, Media: media, Chimes: chimes, Priority: tt.priority, NoResume: true}
+			if err := (&CastDevice{}).Speak(context.Background(), "hello", opts); err != nil {
+				t.Fatal(err)
+			}
+			if len(media.published) != len(tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, media.published)
+			}
+			for idx := range tt.want {
+				if media.published[idx] != tt.want[idx] {
+					t.Errorf("want %v, got %v", tt.want, media.published)
+				}
+			}
+		})
+	}
+}
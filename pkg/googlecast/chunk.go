@@ -0,0 +1,155 @@
+package googlecast
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	sentenceTerminators = "。．！？!?\n"
+	clauseSeparators    = "、，,;:；："
+)
+
+// TextLimiter is implemented by TTS providers which limit text length of a request
+type TextLimiter interface {
+	MaxTextLength() int
+}
+
+// joinMessages joins messages into a text terminating each message as a sentence
+func joinMessages(msgs []string, lang string) string {
+	sentences := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		msg = strings.TrimSpace(msg)
+		if msg == "" {
+			continue
+		}
+		if runes := []rune(msg); !strings.ContainsRune(sentenceTerminators+".", runes[len(runes)-1]) {
+			msg += terminator(lang)
+		}
+		sentences = append(sentences, msg)
+	}
+	return strings.Join(sentences, separator(lang))
+}
+
+// splitText splits a text into chunks within max characters on sentence boundaries.
+// A sentence longer than max is split on clause separators, spaces, or max characters.
+func splitText(text, lang string, max int) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if max <= 0 || len([]rune(text)) <= max {
+		return []string{text}
+	}
+	parts := []string{}
+	for _, sentence := range splitSentences(text) {
+		parts = append(parts, splitLong(sentence, lang, max)...)
+	}
+	return pack(parts, separator(lang), max)
+}
+
+// splitSentences splits a text after sentence terminators.
+// A period splits only if a space follows it, so that "3.5" or "e.g" are kept.
+func splitSentences(text string) []string {
+	return splitAfter(text, func(r, next rune) bool {
+		if r == '.' {
+			return next == 0 || unicode.IsSpace(next)
+		}
+		return strings.ContainsRune(sentenceTerminators, r)
+	})
+}
+
+func splitLong(sentence, lang string, max int) []string {
+	if len([]rune(sentence)) <= max {
+		return []string{sentence}
+	}
+	parts := []string{}
+	clauses := splitAfter(sentence, func(r, _ rune) bool {
+		return strings.ContainsRune(clauseSeparators, r)
+	})
+	for _, clause := range clauses {
+		if len([]rune(clause)) <= max {
+			parts = append(parts, clause)
+			continue
+		}
+		for _, word := range strings.Fields(clause) {
+			parts = append(parts, splitRunes(word, max)...)
+		}
+	}
+	return pack(parts, separator(lang), max)
+}
+
+// pack joins parts with sep as long as a chunk is within max characters
+func pack(parts []string, sep string, max int) []string {
+	chunks := []string{}
+	current := ""
+	for _, part := range parts {
+		if current == "" {
+			current = part
+			continue
+		}
+		if len([]rune(current))+len([]rune(sep))+len([]rune(part)) > max {
+			chunks = append(chunks, current)
+			current = part
+			continue
+		}
+		current += sep + part
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
+
+// splitAfter splits a text after runes which isBoundary returns true, and trims spaces of each part
+func splitAfter(text string, isBoundary func(r, next rune) bool) []string {
+	runes := []rune(text)
+	parts := []string{}
+	start := 0
+	for idx, r := range runes {
+		var next rune
+		if idx+1 < len(runes) {
+			next = runes[idx+1]
+		}
+		if !isBoundary(r, next) {
+			continue
+		}
+		if part := strings.TrimSpace(string(runes[start : idx+1])); part != "" {
+			parts = append(parts, part)
+		}
+		start = idx + 1
+	}
+	if part := strings.TrimSpace(string(runes[start:])); part != "" {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func splitRunes(text string, max int) []string {
+	runes := []rune(text)
+	parts := []string{}
+	for len(runes) > max {
+		parts = append(parts, string(runes[:max]))
+		runes = runes[max:]
+	}
+	return append(parts, string(runes))
+}
+
+func terminator(lang string) string {
+	if isCJK(lang) {
+		return "。"
+	}
+	return "."
+}
+
+func separator(lang string) string {
+	if isCJK(lang) {
+		return ""
+	}
+	return " "
+}
+
+func isCJK(lang string) bool {
+	lang = strings.ToLower(lang)
+	return strings.HasPrefix(lang, "ja") || strings.HasPrefix(lang, "zh")
+}
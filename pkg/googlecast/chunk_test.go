@@ -0,0 +1,69 @@
+package googlecast
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	tests := map[string]struct {
+		text string
+		lang string
+		max  int
+		want []string
+	}{
+		"short": {
+			text: "Hello.",
+			lang: "en",
+			max:  200,
+			want: []string{"Hello."},
+		},
+		"english sentences": {
+			text: "Meeting starts at 10.30 today. Lunch with Bob. Call mom!",
+			lang: "en",
+			max:  32,
+			want: []string{"Meeting starts at 10.30 today.", "Lunch with Bob. Call mom!"},
+		},
+		"japanese sentences": {
+			text: "10時から会議。12時からランチ。15時から打ち合わせ。",
+			lang: "ja",
+			max:  17,
+			want: []string{"10時から会議。12時からランチ。", "15時から打ち合わせ。"},
+		},
+		"long sentence on clauses": {
+			text: "first clause is here, second clause is here, third",
+			lang: "en",
+			max:  25,
+			want: []string{"first clause is here,", "second clause is here,", "third"},
+		},
+		"long word": {
+			text: strings.Repeat("あ", 25),
+			lang: "ja",
+			max:  10,
+			want: []string{strings.Repeat("あ", 10), strings.Repeat("あ", 10), strings.Repeat("あ", 5)},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := splitText(tt.text, tt.lang, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+			for _, chunk := range got {
+				if len([]rune(chunk)) > tt.max {
+					t.Errorf("chunk exceeds %d: %q", tt.max, chunk)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinMessages(t *testing.T) {
+	if got := joinMessages([]string{"Lunch will start", "Call mom."}, "en"); got != "Lunch will start. Call mom." {
+		t.Errorf("unexpected english text: %q", got)
+	}
+	if got := joinMessages([]string{"10時から会議。", "12時からランチ"}, "ja"); got != "10時から会議。12時からランチ。" {
+		t.Errorf("unexpected japanese text: %q", got)
+	}
+}
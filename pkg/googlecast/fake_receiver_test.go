@@ -0,0 +1,372 @@
+package googlecast
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	cast "github.com/barnybug/go-cast"
+	"github.com/barnybug/go-cast/api"
+	"github.com/barnybug/go-cast/controllers"
+	"github.com/gogo/protobuf/proto"
+	"github.com/hashicorp/mdns"
+)
+
+const (
+	namespaceConnection = "urn:x-cast:com.google.cast.tp.connection"
+	namespaceHeartbeat  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	namespaceReceiver   = "urn:x-cast:com.google.cast.receiver"
+	backdropAppID       = "E8C28D3C"
+)
+
+type (
+	// fakeReceiver is an in-process Cast v2 receiver, which speaks CastMessage over TLS.
+	fakeReceiver struct {
+		t  *testing.T
+		ln net.Listener
+
+		mu    sync.Mutex
+		conns []net.Conn
+		// appID is a running app. The backdrop app does not support media.
+		appID   string
+		appName string
+		volume  float64
+		media   *fakeMedia
+		// loaded records media loaded on any apps
+		loaded []fakeLoad
+		// failLoads is a number of LOAD requests to fail
+		failLoads int
+		// dropLoads is a number of LOAD requests not to respond, as if the receiver hung
+		dropLoads int
+		// rejectApp is an app which fails LOAD requests, as apps which need credentials
+		rejectApp string
+		// playDuration is a duration until loaded media finishes
+		playDuration time.Duration
+		// idleReason is reported when loaded media finishes
+		idleReason string
+		// keepStatus keeps the idle status of finished media, as devices do until other media is loaded
+		keepStatus bool
+		// sessions is the last media session ID
+		sessions int
+		// volumes records volume levels set by senders
+		volumes []float64
+	}
+
+	fakeMedia struct {
+		sessionID   int
+		item        controllers.MediaItem
+		state       string
+		idleReason  string
+		currentTime float64
+	}
+
+	fakeLoad struct {
+		appID       string
+		item        controllers.MediaItem
+		currentTime int
+		autoplay    bool
+	}
+
+	// fakeMessage is a payload of requests from senders
+	fakeMessage struct {
+		Type      string                `json:"type"`
+		RequestID int                   `json:"requestId"`
+		AppID     string                `json:"appId"`
+		Volume    *controllers.Volume   `json:"volume"`
+		Media     controllers.MediaItem `json:"media"`
+		Current   int                   `json:"currentTime"`
+		Autoplay  bool                  `json:"autoplay"`
+	}
+)
+
+// newFakeReceiver starts a fake receiver on a loopback address
+func newFakeReceiver(t *testing.T) *fakeReceiver {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeReceiver{t: t, ln: ln, appID: backdropAppID, appName: "Backdrop", volume: 0.1, playDuration: 50 * time.Millisecond, idleReason: PlaybackFinished}
+	go f.serve()
+	return f
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake-cast"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (f *fakeReceiver) port() int {
+	return f.ln.Addr().(*net.TCPAddr).Port
+}
+
+// entry returns a service entry of the receiver as found by mDNS
+func (f *fakeReceiver) entry(id, name string) *mdns.ServiceEntry {
+	ip := net.IPv4(127, 0, 0, 1)
+	return &mdns.ServiceEntry{
+		Name:       name,
+		AddrV4:     ip,
+		Addr:       ip,
+		Port:       f.port(),
+		InfoFields: []string{"id=" + id, "fn=" + name, "md=Google Home Mini"},
+	}
+}
+
+// advertise responds mDNS queries of the receiver until the test finishes
+func (f *fakeReceiver) advertise(id, name string) {
+	f.t.Helper()
+	service, err := mdns.NewMDNSService(id, googleCastServiceName, "", "fake-cast.local.", f.port(),
+		[]net.IP{net.IPv4(127, 0, 0, 1)}, f.entry(id, name).InfoFields)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		f.t.Skipf("mDNS is not available: %s", err)
+	}
+	f.t.Cleanup(func() { server.Shutdown() })
+}
+
+func (f *fakeReceiver) Close() {
+	f.ln.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+// playing starts media on an app, as if a sender casted it
+func (f *fakeReceiver) playing(appID, appName string, item controllers.MediaItem, currentTime float64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.appID, f.appName = appID, appName
+	f.sessions++
+	f.media = &fakeMedia{sessionID: f.sessions, item: item, state: playerStatePlaying, currentTime: currentTime}
+}
+
+// stop makes the media idle by the reason
+func (f *fakeReceiver) stop(idleReason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.media != nil {
+		f.finishLocked(f.media, idleReason)
+	}
+}
+
+// finishLocked makes the media idle, and returns its status. The media is removed unless keepStatus is true.
+func (f *fakeReceiver) finishLocked(media *fakeMedia, idleReason string) map[string]interface{} {
+	media.state, media.idleReason = playerStateIdle, idleReason
+	if !f.keepStatus {
+		f.media = nil
+	}
+	return media.status()
+}
+
+func (f *fakeReceiver) loads() []fakeLoad {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]fakeLoad{}, f.loaded...)
+}
+
+func (f *fakeReceiver) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, conn)
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeReceiver) handle(conn net.Conn) {
+	defer conn.Close()
+	var writeMu sync.Mutex
+	send := func(msg *api.CastMessage, payload interface{}) {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			f.t.Errorf("marshal payload: %s", err)
+			return
+		}
+		data := string(b)
+		reply := &api.CastMessage{
+			ProtocolVersion: api.CastMessage_CASTV2_1_0.Enum(),
+			SourceId:        msg.DestinationId,
+			DestinationId:   msg.SourceId,
+			Namespace:       msg.Namespace,
+			PayloadType:     api.CastMessage_STRING.Enum(),
+			PayloadUtf8:     &data,
+		}
+		packet, err := proto.Marshal(reply)
+		if err != nil {
+			f.t.Errorf("marshal message: %s", err)
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := binary.Write(conn, binary.BigEndian, uint32(len(packet))); err != nil {
+			return
+		}
+		_, _ = conn.Write(packet)
+	}
+
+	for {
+		var length uint32
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		packet := make([]byte, length)
+		if _, err := io.ReadFull(conn, packet); err != nil {
+			return
+		}
+		msg := &api.CastMessage{}
+		if err := proto.Unmarshal(packet, msg); err != nil {
+			f.t.Errorf("unmarshal message: %s", err)
+			return
+		}
+		var req fakeMessage
+		if err := json.Unmarshal([]byte(msg.GetPayloadUtf8()), &req); err != nil {
+			f.t.Errorf("unmarshal payload: %s", err)
+			return
+		}
+		switch msg.GetNamespace() {
+		case namespaceConnection:
+		case namespaceHeartbeat:
+			if req.Type == "PING" {
+				send(msg, map[string]string{"type": "PONG"})
+			}
+		case namespaceReceiver:
+			send(msg, f.receiverStatus(req))
+		case mediaNamespace:
+			if status := f.mediaStatus(req, func(payload interface{}) {
+				send(msg, payload)
+			}); status != nil {
+				send(msg, status)
+			}
+		}
+	}
+}
+
+func (f *fakeReceiver) receiverStatus(req fakeMessage) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req.Type {
+	case "LAUNCH":
+		if f.appID != req.AppID {
+			// launching an app stops the previous app
+			f.appID, f.appName, f.media = req.AppID, "App "+req.AppID, nil
+		}
+	case "SET_VOLUME":
+		if req.Volume != nil && req.Volume.Level != nil {
+			f.volume = *req.Volume.Level
+			f.volumes = append(f.volumes, f.volume)
+		}
+	}
+	namespaces := []map[string]string{}
+	if f.appID != backdropAppID {
+		namespaces = append(namespaces, map[string]string{"name": mediaNamespace})
+	}
+	muted := false
+	return map[string]interface{}{
+		"type":      "RECEIVER_STATUS",
+		"requestId": req.RequestID,
+		"status": map[string]interface{}{
+			"applications": []map[string]interface{}{{
+				"appId":       f.appID,
+				"displayName": f.appName,
+				"namespaces":  namespaces,
+				"sessionId":   "session-" + f.appID,
+				"statusText":  "",
+				"transportId": "transport-" + f.appID,
+			}},
+			"volume": controllers.Volume{Level: &f.volume, Muted: &muted},
+		},
+	}
+}
+
+// mediaStatus handles a request of media namespace. notify sends a status after the playback finishes.
+// It returns nil for a dropped request.
+func (f *fakeReceiver) mediaStatus(req fakeMessage, notify func(payload interface{})) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if req.Type == "LOAD" {
+		f.loaded = append(f.loaded, fakeLoad{appID: f.appID, item: req.Media, currentTime: req.Current, autoplay: req.Autoplay})
+		if f.dropLoads > 0 {
+			f.dropLoads--
+			return nil
+		}
+		if f.appID == f.rejectApp {
+			return map[string]interface{}{"type": "LOAD_FAILED", "requestId": req.RequestID}
+		}
+		if f.failLoads > 0 {
+			f.failLoads--
+			return map[string]interface{}{"type": "LOAD_FAILED", "requestId": req.RequestID}
+		}
+		f.sessions++
+		media := &fakeMedia{sessionID: f.sessions, item: req.Media, state: playerStatePlaying, currentTime: float64(req.Current)}
+		f.media = media
+		if f.appID == cast.AppMedia {
+			time.AfterFunc(f.playDuration, func() {
+				f.mu.Lock()
+				if f.media != media {
+					f.mu.Unlock()
+					return
+				}
+				status := f.finishLocked(media, f.idleReason)
+				f.mu.Unlock()
+				notify(map[string]interface{}{"type": "MEDIA_STATUS", "status": []map[string]interface{}{status}})
+			})
+		}
+	}
+	statuses := []map[string]interface{}{}
+	if f.media != nil {
+		statuses = append(statuses, f.media.status())
+	}
+	return map[string]interface{}{"type": "MEDIA_STATUS", "requestId": req.RequestID, "status": statuses}
+}
+
+func (m *fakeMedia) status() map[string]interface{} {
+	status := map[string]interface{}{
+		"mediaSessionId": m.sessionID,
+		"playerState":    m.state,
+		"currentTime":    m.currentTime,
+		"media":          m.item,
+	}
+	if m.idleReason != "" {
+		status["idleReason"] = m.idleReason
+	}
+	return status
+}
+
+func (f *fakeReceiver) String() string {
+	return fmt.Sprintf("fake receiver on %s", f.ln.Addr())
+}
@@ -2,6 +2,8 @@ package googlecast
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	cast "github.com/barnybug/go-cast"
+	"github.com/barnybug/go-cast/api"
 	"github.com/barnybug/go-cast/controllers"
 	castnet "github.com/barnybug/go-cast/net"
 	"github.com/hashicorp/mdns"
@@ -19,151 +22,554 @@ const (
 	googleCastServiceName = "_googlecast._tcp"
 	modelTypePrefix       = "md"
 	friendryNamePrefix    = "fn"
-	googleHomeModelPrefix = "md=Google"
+	idPrefix              = "id"
+	castGroupModel        = "Google Cast Group"
+
+	playerStateIdle     = "IDLE"
+	connectTimeout      = 10 * time.Second
+	playbackTimeout     = 10 * time.Minute
+	mediaStatusInterval = 5 * time.Second
+)
+
+// States of playbacks. Devices report other idle reasons such as CANCELLED or INTERRUPTED as well.
+const (
+	// PlaybackLoaded is a state of a playback which was not waited
+	PlaybackLoaded = "LOADED"
+	// PlaybackFinished is a state of a playback which finished
+	PlaybackFinished = "FINISHED"
+	// PlaybackError is a state of a playback which the device failed
+	PlaybackError = "ERROR"
+)
+
+// PlaybackStatus is a final status of a playback
+type PlaybackStatus struct {
+	// State is an idle reason of the device, or PlaybackLoaded if the playback was not waited
+	State string
+	// Duration is a duration from loading media until the device became idle
+	Duration time.Duration
+}
+
+// lookupTimeout is a duration to wait for mDNS responses
+var lookupTimeout = 15 * time.Second
+
+var (
+	errPlaybackFailed = errors.New("cast device failed to play media")
+	errNoAddress      = errors.New("cast device has no address")
 )
 
 // CastDevice is cast-able device contains cast client
 type CastDevice struct {
 	*mdns.ServiceEntry
+
+	mu     sync.Mutex
 	client *cast.Client
+	// receiver is a channel of the receiver on the client
+	receiver *castChannel
 }
 
-// Connect connects required services to cast
+// Connect connects required services to cast, and replaces the current connection
 func (g *CastDevice) Connect(ctx context.Context) error {
-	return g.client.Connect(ctx)
+	_, err := g.reconnect(ctx)
+	return err
 }
 
-// Close calls client's close func
+// Close closes the client. The device connects again on the next playback.
 func (g *CastDevice) Close() {
-	g.client.Close()
+	g.mu.Lock()
+	client := g.client
+	g.client, g.receiver = nil, nil
+	g.mu.Unlock()
+	if client != nil {
+		client.Close()
+	}
+}
+
+func (g *CastDevice) castClient() *cast.Client {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.client
+}
+
+// connected returns the receiver channel, and connects the device if it is not connected
+func (g *CastDevice) connected(ctx context.Context) (*castChannel, error) {
+	g.mu.Lock()
+	rec := g.receiver
+	g.mu.Unlock()
+	if rec != nil {
+		return rec, nil
+	}
+	return g.reconnect(ctx)
+}
+
+// reconnect connects a new client, and closes the previous one. It returns the receiver channel of the new client.
+func (g *CastDevice) reconnect(ctx context.Context) (*castChannel, error) {
+	if g.ServiceEntry == nil || g.AddrV4 == nil {
+		return nil, permanent(errNoAddress)
+	}
+	ctx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+	client := cast.NewClient(g.AddrV4, g.Port)
+	if err := client.Connect(ctx); err != nil {
+		// a half-connected client can not be closed
+		return nil, err
+	}
+	rec := newCastChannel(client.NewChannel(cast.DefaultSender, cast.DefaultReceiver, receiverNamespace), receiverResponses)
+	g.mu.Lock()
+	old := g.client
+	g.client, g.receiver = client, rec
+	g.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return rec, nil
+}
+
+// ID returns an unique ID of the device. It is an advertised UUID, or an address if not advertised.
+func (g *CastDevice) ID() string {
+	if id := g.info(idPrefix); id != "" {
+		return id
+	}
+	if g.ServiceEntry == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", g.AddrV4, g.Port)
 }
 
-// Speak speaks given text on cast device
-func (g *CastDevice) Speak(ctx context.Context, text, lang string) error {
-	url, err := tts(text, lang)
+// Name returns a friendly name of the device
+func (g *CastDevice) Name() string {
+	return g.info(friendryNamePrefix)
+}
+
+// IsGroup reports whether the device is a Cast speaker group.
+// All members of a speaker group play media in sync.
+func (g *CastDevice) IsGroup() bool {
+	return g.info(modelTypePrefix) == castGroupModel
+}
+
+// isTarget reports whether the device is notified.
+// Speaker groups are notified only if named, since their members are notified without names.
+// Model filter does not apply to named speaker groups.
+func (g *CastDevice) isTarget(names []string, filter ModelFilter) bool {
+	if g.IsGroup() {
+		return len(names) > 0 && matchName(names, g.Name())
+	}
+	return matchName(names, g.Name()) && filter.Match(g.info(modelTypePrefix))
+}
+
+func anyDevice(_ *CastDevice) bool { return true }
+
+// info returns a value of the TXT record field
+func (g *CastDevice) info(key string) string {
+	if g.ServiceEntry == nil {
+		return ""
+	}
+	for _, field := range g.InfoFields {
+		if strings.HasPrefix(field, key+"=") {
+			return strings.TrimPrefix(field, key+"=")
+		}
+	}
+	return ""
+}
+
+// Speak speaks given text on cast device with the TTS provider of options.
+// A long text is split into chunks which the provider accepts, and they are played sequentially.
+func (g *CastDevice) Speak(ctx context.Context, text string, opts Options) error {
+	_, err := g.speakChunks(ctx, text, opts, false)
+	return err
+}
+
+// speakChunks speaks chunks of the text sequentially.
+// It waits for each chunk except the last unless waitLast, so that the next chunk does not cut it off.
+// The status has the final state of the last chunk and the total duration.
+func (g *CastDevice) speakChunks(ctx context.Context, text string, opts Options, waitLast bool) (PlaybackStatus, error) {
+	provider := opts.TTS
+	if provider == nil {
+		provider = TranslateTTS{}
+	}
+	max := 0
+	if limiter, ok := provider.(TextLimiter); ok {
+		max = limiter.MaxTextLength()
+	}
+	return g.announce(ctx, opts, waitLast, func(opts Options, waitLast bool) (PlaybackStatus, error) {
+		// only the last chunk notifies that it is loaded
+		partOpts := opts
+		partOpts.loaded = nil
+		if chime := opts.chime(); chime != nil {
+			// the chime is waited, so that the message does not cut it off
+			if _, err := g.playAudio(ctx, chime, partOpts, true); err != nil {
+				log.Printf("[WARN] play chime on %s: %s", g.Name(), err)
+			}
+		}
+		total := PlaybackStatus{}
+		chunks := splitText(text, opts.Locale, max)
+		for idx, chunk := range chunks {
+			chunkOpts := partOpts
+			if idx == len(chunks)-1 {
+				chunkOpts = opts
+			}
+			status, err := g.speak(ctx, provider, chunk, chunkOpts, waitLast || idx < len(chunks)-1)
+			total.State = status.State
+			total.Duration += status.Duration
+			if err != nil {
+				return total, err
+			}
+		}
+		return total, nil
+	})
+}
+
+// playAnnouncement plays audio as an announcement without chimes. It waits for the playback if waitLast.
+func (g *CastDevice) playAnnouncement(ctx context.Context, audio *Audio, opts Options, waitLast bool) (PlaybackStatus, error) {
+	return g.announce(ctx, opts, waitLast, func(opts Options, waitLast bool) (PlaybackStatus, error) {
+		return g.playAudio(ctx, audio, opts, waitLast)
+	})
+}
+
+// announce calls play, and restores media interrupted by the announcement and the volume afterwards.
+// play waits for the last playback if it is required to restore them,
+// and the announcement is not notified as loaded until they are restored.
+func (g *CastDevice) announce(ctx context.Context, opts Options, waitLast bool, play func(opts Options, waitLast bool) (PlaybackStatus, error)) (PlaybackStatus, error) {
+	if !opts.NoResume {
+		if p := g.interruptedPlayback(ctx); p != nil {
+			defer g.resume(p)
+			waitLast = true
+			opts.loaded = nil
+		}
+	}
+	if opts.Volume > 0 {
+		defer g.overrideVolume(ctx, opts.Volume)()
+		waitLast = true
+		opts.loaded = nil
+	}
+	return play(opts, waitLast)
+}
+
+// speak speaks a chunk
+func (g *CastDevice) speak(ctx context.Context, provider TTSProvider, text string, opts Options, wait bool) (PlaybackStatus, error) {
+	audio, err := provider.Synthesize(ctx, text, opts.Locale, opts.Voice)
 	if err != nil {
-		return err
+		return PlaybackStatus{}, err
 	}
-	return g.Play(ctx, url)
+	return g.playAudio(ctx, audio, opts, wait)
 }
 
-// LookupAndConnect retrieves cast-able google home devices
-func LookupAndConnect(ctx context.Context, max int, friendryName string) []*CastDevice {
+// playAudio plays audio. Audio data is hosted by the media host until the playback finishes,
+// so that it is notified as loaded after the playback.
+func (g *CastDevice) playAudio(ctx context.Context, audio *Audio, opts Options, wait bool) (PlaybackStatus, error) {
+	req := mediaRequest{url: audio.URL, contentType: audio.ContentType, wait: wait, retry: opts.Retry, loaded: opts.loaded}
+	if audio.URL != nil {
+		return playMedia(g, ctx, req)
+	}
+	if opts.Media == nil {
+		return PlaybackStatus{}, errNoAudioURL
+	}
+	u, err := opts.Media.Publish(audio.Data, audio.ContentType)
+	if err != nil {
+		return PlaybackStatus{}, err
+	}
+	defer opts.Media.Release(u)
+	req.url, req.wait, req.loaded = u, true, nil
+	return playMedia(g, ctx, req)
+}
+
+// lookup discovers devices by mDNS, and converts found entries to devices by convert.
+// Entries are converted after the query finishes, since mdns keeps updating entries which were sent,
+// and a slow consumer makes mdns drop entries.
+func lookup(convert func(entry *mdns.ServiceEntry) *CastDevice) []*CastDevice {
 	// https://github.com/hashicorp/mdns
-	entriesCh := make(chan *mdns.ServiceEntry, max)
-	resultCh := make(chan *CastDevice, max)
-	wg := new(sync.WaitGroup)
-	go func(ctx context.Context, friendryName string) {
+	entriesCh := make(chan *mdns.ServiceEntry, 16)
+	entries := []*mdns.ServiceEntry{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
 		for entry := range entriesCh {
-			log.Printf("got entry %v\n", entry)
-			wg.Add(1)
-			if cast := lookupClient(ctx, entry, friendryName); cast != nil {
-				resultCh <- cast
-			}
-			wg.Done()
+			entries = append(entries, entry)
 		}
-	}(ctx, friendryName)
+	}()
 
 	p := mdns.QueryParam{
 		Service:             googleCastServiceName,
 		Domain:              "local",
-		Timeout:             time.Second * 15,
+		Timeout:             lookupTimeout,
 		Entries:             entriesCh,
 		WantUnicastResponse: false, // TODO(reddaly): Change this default.
 	}
-	if err := mdns.Query(&p); err != nil {
+	err := mdns.Query(&p)
+	close(entriesCh)
+	<-done
+	if err != nil {
+		log.Printf("[ERROR] Failed to query mDNS: %s", err)
 		return nil
 	}
-	close(entriesCh)
-	wg.Wait()
-	close(resultCh)
-	results := make([]*CastDevice, 0, max)
-	for cast := range resultCh {
-		fmt.Printf("%v\n", cast)
-		if cast != nil {
-			results = append(results, cast)
+	results := []*CastDevice{}
+	for _, entry := range entries {
+		log.Printf("got entry %v\n", entry)
+		if device := convert(entry); device != nil {
+			results = append(results, device)
 		}
 	}
 	return results
 }
 
-func lookupClient(ctx context.Context, entry *mdns.ServiceEntry, friendryName string) *CastDevice {
-	var client *cast.Client
-	valid := true
-	// Fields : https://blog.oakbits.com/google-cast-protocol-discovery-and-connection.html
-	for _, field := range entry.InfoFields {
-		// check device friendly name
-		if friendryName != "" && strings.HasPrefix(field, friendryNamePrefix) {
-			if field != fmt.Sprintf("%s=%s", friendryNamePrefix, friendryName) {
-				valid = false
-				continue
-			}
+// Play plays media contents on cast device, and returns after the device accepted the media.
+// The content type is detected by the extension of the URL.
+func (g *CastDevice) Play(ctx context.Context, url *url.URL) error {
+	_, err := g.play(ctx, mediaRequest{url: url, contentType: DetectContentType(url.Path, nil)})
+	return err
+}
+
+// PlayAndWait plays media contents on cast device and blocks until the playback finishes
+func (g *CastDevice) PlayAndWait(ctx context.Context, url *url.URL) (PlaybackStatus, error) {
+	return g.play(ctx, mediaRequest{url: url, contentType: DetectContentType(url.Path, nil), wait: true})
+}
+
+// mediaRequest is a request to play media on a device
+type mediaRequest struct {
+	url         *url.URL
+	contentType string
+	// wait blocks until the playback finishes
+	wait  bool
+	retry RetryPolicy
+	// loaded is called after the device accepted the media if not nil
+	loaded func()
+}
+
+// playMedia plays media on a device. Tests replace it to play without cast devices.
+var playMedia = (*CastDevice).play
+
+// play loads media on the device, and retries with a new connection on failure.
+// The playback itself is not retried, so that a partially played announcement is not repeated.
+func (g *CastDevice) play(ctx context.Context, req mediaRequest) (PlaybackStatus, error) {
+	var session *mediaSession
+	err := req.retry.do(ctx, func(ctx context.Context, attempt int) error {
+		// the cached client may be dead after a failure or a timeout
+		connect := g.connected
+		if attempt > 0 {
+			connect = g.reconnect
 		}
-		if strings.HasPrefix(field, modelTypePrefix) {
-			if !strings.HasPrefix(field, googleHomeModelPrefix) {
-				valid = false
-				continue
-			}
-			client = cast.NewClient(entry.AddrV4, entry.Port)
-			if err := client.Connect(ctx); err != nil {
-				log.Printf("[ERROR] Failed to connect: %s", err)
-			}
+		rec, err := connect(ctx)
+		if err != nil {
+			return err
 		}
+		session, err = g.load(ctx, rec, req.url, req.contentType)
+		return err
+	})
+	if err != nil {
+		return PlaybackStatus{}, err
 	}
-	if valid {
-		return &CastDevice{entry, client}
+	defer session.close()
+	if req.loaded != nil {
+		req.loaded()
 	}
-	return nil
+	if !req.wait {
+		return PlaybackStatus{State: PlaybackLoaded}, nil
+	}
+	start := time.Now()
+	state, err := waitFinished(ctx, session)
+	return PlaybackStatus{State: state, Duration: time.Since(start)}, err
 }
 
-// tts provides text-to-speech sound url.
-// NOTE: it seems to be unofficial.
-func tts(text, lang string) (*url.URL, error) {
-	base := "https://translate.google.com/translate_tts?client=tw-ob&ie=UTF-8&q=%s&tl=%s"
-	return url.Parse(fmt.Sprintf(base, url.QueryEscape(text), url.QueryEscape(lang)))
+// mediaSession is a connection to the media namespace of a running app
+type mediaSession struct {
+	conn   *castnet.Connection
+	media  *castChannel
+	events chan controllers.MediaStatus
+	// id is a media session ID of the loaded media. 0 is unknown.
+	id int
 }
 
-// Play plays media contents on cast device
-func (g *CastDevice) Play(ctx context.Context, url *url.URL) error {
-	conn := castnet.NewConnection()
-	log.Printf("device client %v\n", g.client)
-	if g.client == nil {
-		log.Println("client pointer is nil")
-		return nil
+// load launches the media receiver app, and loads media on it
+func (g *CastDevice) load(ctx context.Context, rec *castChannel, url *url.URL, contentType string) (*mediaSession, error) {
+	app, err := launchApp(ctx, rec, cast.AppMedia)
+	if err != nil {
+		return nil, err
 	}
-	if err := conn.Connect(ctx, g.AddrV4, g.Port); err != nil {
-		return err
+	session, err := g.openMedia(ctx, *app.TransportId)
+	if err != nil {
+		return nil, err
 	}
-	if conn != nil {
-		defer conn.Close()
+
+	if contentType == "" {
+		contentType = DetectContentType(url.Path, nil)
 	}
-	rec := g.client.Receiver()
-	if rec == nil {
-		log.Printf("client does not have receiver %v", g.client)
-		return nil
+	mediaItem := controllers.MediaItem{
+		ContentId:   url.String(),
+		ContentType: contentType,
+		StreamType:  "BUFFERED",
 	}
-	status, err := rec.LaunchApp(ctx, cast.AppMedia)
+
+	log.Printf("[INFO] Load media: content_id=%s", mediaItem.ContentId)
+	resp, err := session.loadMedia(ctx, mediaItem, 0, true)
 	if err != nil {
-		return err
+		session.close()
+		return nil, err
+	}
+	session.id = loadedSessionID(resp)
+	return session, nil
+}
+
+// loadedSessionID returns a media session ID in the response of LOAD, or 0 if it is not found
+func loadedSessionID(message *api.CastMessage) int {
+	var resp controllers.MediaStatusResponse
+	if err := json.Unmarshal([]byte(message.GetPayloadUtf8()), &resp); err != nil {
+		return 0
 	}
-	app := status.GetSessionByAppId(cast.AppMedia)
-	cc := controllers.NewConnectionController(conn, g.client.Events, cast.DefaultSender, *app.TransportId)
+	for _, status := range resp.Status {
+		if status.MediaSessionID != 0 {
+			return status.MediaSessionID
+		}
+	}
+	return 0
+}
+
+// openMedia connects to the media namespace of a running app
+func (g *CastDevice) openMedia(ctx context.Context, transportID string) (_ *mediaSession, err error) {
+	conn := castnet.NewConnection()
+	// channels are opened before the receive loop starts
+	cc := controllers.NewConnectionController(conn, nil, cast.DefaultSender, transportID)
+	channel := conn.NewChannel(cast.DefaultSender, transportID, mediaNamespace)
+	session := &mediaSession{conn: conn, media: newCastChannel(channel, mediaResponses), events: make(chan controllers.MediaStatus, 16)}
+	channel.OnMessage("MEDIA_STATUS", session.onStatus)
+	if err := conn.Connect(ctx, g.AddrV4, g.Port); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
 	if err := cc.Start(ctx); err != nil {
-		return err
+		return nil, err
 	}
-	media := controllers.NewMediaController(conn, g.client.Events, cast.DefaultSender, *app.TransportId)
-	if err := media.Start(ctx); err != nil {
-		return err
+	return session, nil
+}
+
+// onStatus passes media status to events. Status is dropped if nobody receives events.
+func (s *mediaSession) onStatus(message *api.CastMessage) {
+	var resp controllers.MediaStatusResponse
+	if err := json.Unmarshal([]byte(message.GetPayloadUtf8()), &resp); err != nil {
+		return
+	}
+	for _, status := range resp.Status {
+		select {
+		case s.events <- *status:
+		default:
+		}
 	}
+}
 
-	mediaItem := controllers.MediaItem{
-		ContentId:   url.String(),
-		ContentType: "audio/mp3",
-		StreamType:  "BUFFERED",
+// status returns media status of the app
+func (s *mediaSession) status(ctx context.Context) ([]*controllers.MediaStatus, error) {
+	message, err := s.media.request(ctx, "GET_STATUS", nil)
+	if err != nil {
+		return nil, err
 	}
+	var resp controllers.MediaStatusResponse
+	if err := json.Unmarshal([]byte(message.GetPayloadUtf8()), &resp); err != nil {
+		return nil, fmt.Errorf("unmarshal media status: %w", err)
+	}
+	return resp.Status, nil
+}
 
-	log.Printf("[INFO] Load media: content_id=%s", mediaItem.ContentId)
-	_, err = media.LoadMedia(ctx, mediaItem, 0, true, nil)
+// loadMedia loads media at the position in seconds
+func (s *mediaSession) loadMedia(ctx context.Context, item controllers.MediaItem, currentTime int, autoplay bool) (*api.CastMessage, error) {
+	return s.media.request(ctx, "LOAD", func(headers castnet.PayloadHeaders) interface{} {
+		return &controllers.LoadMediaCommand{PayloadHeaders: headers, Media: item, CurrentTime: currentTime, Autoplay: autoplay}
+	})
+}
 
-	return err
+func (s *mediaSession) close() {
+	s.conn.Close()
+}
+
+// owns reports whether the status is of the loaded media, rather than previous media on the app
+func (s *mediaSession) owns(status *controllers.MediaStatus) bool {
+	return s.id == 0 || status.MediaSessionID == s.id
+}
+
+// waitFinished watches media status of the session until the player becomes IDLE, and returns the idle reason
+func waitFinished(ctx context.Context, session *mediaSession) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, playbackTimeout)
+	defer cancel()
+	ticker := time.NewTicker(mediaStatusInterval)
+	defer ticker.Stop()
+	started := false
+	for {
+		select {
+		case status := <-session.events:
+			if !session.owns(&status) {
+				continue
+			}
+			switch status.PlayerState {
+			case playerStateIdle:
+				if status.IdleReason == PlaybackError {
+					return PlaybackError, errPlaybackFailed
+				}
+				if status.IdleReason != "" {
+					return status.IdleReason, nil
+				}
+				if started {
+					return PlaybackFinished, nil
+				}
+			default:
+				started = true
+			}
+		case <-ticker.C:
+			// status events may be dropped, so polls status as well
+			statuses, err := session.status(ctx)
+			if err != nil {
+				return "", err
+			}
+			found := false
+			for _, status := range statuses {
+				found = found || session.owns(status)
+			}
+			if started && !found {
+				return PlaybackFinished, nil
+			}
+		case <-ctx.Done():
+			return "", fmt.Errorf("wait for playback: %w", ctx.Err())
+		}
+	}
+}
+
+// DeviceInfo is a summary of a device
+type DeviceInfo struct {
+	Name      string `json:"name"`
+	Model     string `json:"model"`
+	IP        string `json:"ip"`
+	Port      int    `json:"port"`
+	UUID      string `json:"uuid"`
+	Connected bool   `json:"connected"`
+}
+
+// Info returns a summary of the device
+func (g *CastDevice) Info() DeviceInfo {
+	info := DeviceInfo{
+		Name:      g.Name(),
+		Model:     g.info(modelTypePrefix),
+		UUID:      g.info(idPrefix),
+		Connected: g.castClient() != nil,
+	}
+	if g.ServiceEntry != nil {
+		info.IP = g.AddrV4.String()
+		info.Port = g.Port
+	}
+	return info
+}
+
+// ListDevices returns summaries of discovered devices of any models including speaker groups.
+// It returns cached devices unless refresh is true or the cache is expired.
+func ListDevices(ctx context.Context, max int, refresh bool) []DeviceInfo {
+	if refresh {
+		discovered.refresh()
+	} else {
+		discovered.refreshIfExpired()
+	}
+	devices, _ := discovered.find(max, anyDevice)
+	infos := make([]DeviceInfo, len(devices))
+	for idx, device := range devices {
+		infos[idx] = device.Info()
+	}
+	return infos
 }
@@ -1,7 +1,494 @@
 package googlecast
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
 
-func TestLookupAndConnect(t *testing.T) {
+	cast "github.com/barnybug/go-cast"
+	"github.com/barnybug/go-cast/controllers"
+	"github.com/hashicorp/mdns"
 
+	"github.com/tomoyamachi/notifyhome/pkg/multierr"
+)
+
+func TestMain(m *testing.M) {
+	// devices in tests do not have cast receivers
+	playMedia = fakePlay
+	// fake receivers respond to mDNS immediately
+	lookupTimeout = time.Second
+
+	os.Exit(m.Run())
+}
+
+func fakePlay(_ *CastDevice, _ context.Context, req mediaRequest) (PlaybackStatus, error) {
+	if req.loaded != nil {
+		req.loaded()
+	}
+	if !req.wait {
+		return PlaybackStatus{State: PlaybackLoaded}, nil
+	}
+	return PlaybackStatus{State: PlaybackFinished, Duration: time.Millisecond}, nil
+}
+
+type fakeTTS struct {
+	audio *Audio
+	err   error
+	calls []string
+}
+
+func (f *fakeTTS) Synthesize(_ context.Context, text, lang, voice string) (*Audio, error) {
+	f.calls = append(f.calls, text+"|"+lang+"|"+voice)
+	return f.audio, f.err
+}
+
+type fakeMediaHost struct {
+	published []string
+	released  []string
+}
+
+func (f *fakeMediaHost) Publish(data []byte, contentType string) (*url.URL, error) {
+	f.published = append(f.published, string(data)+"|"+contentType)
+	return url.Parse("http://192.168.0.2:8000/media/token")
+}
+
+func (f *fakeMediaHost) Release(u *url.URL) {
+	f.released = append(f.released, u.String())
+}
+
+// useCastReceiver plays media on receivers instead of fakePlay until the test finishes
+func useCastReceiver(t *testing.T) {
+	playMedia = (*CastDevice).play
+	t.Cleanup(func() { playMedia = fakePlay })
+}
+
+func TestNotifyDiscovery(t *testing.T) {
+	useCastReceiver(t)
+	receiver := newFakeReceiver(t)
+	defer receiver.Close()
+	receiver.advertise("fake-lookup", "Fake Lookup")
+	defer discovered.clear()
+
+	u, _ := url.Parse("http://example.com/message.mp3")
+	opts := Options{
+		TTS:          &fakeTTS{audio: &Audio{URL: u}},
+		FriendlyName: "Fake Lookup",
+		Retry:        RetryPolicy{MaxAttempts: 1},
+	}
+	result, err := Notify(context.Background(), opts, []string{"dinner is ready"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Devices) != 1 {
+		t.Fatalf("want 1 device result, got %d", len(result.Devices))
+	}
+	if got := result.Devices[0]; got.DeviceID != "fake-lookup" || got.DeviceName != "Fake Lookup" || got.Err != nil {
+		t.Errorf("unexpected result: %+v", got)
+	}
+	if loads := receiver.loads(); len(loads) != 1 || loads[0].item.ContentId != u.String() {
+		t.Errorf("unexpected loads: %+v", loads)
+	}
+
+	opts.Models = ModelFilter{Exclude: []string{"*Mini"}}
+	result, err = Notify(context.Background(), opts, []string{"dinner is ready"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Devices) != 0 {
+		t.Errorf("excluded model is notified: %+v", result.Devices)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	useCastReceiver(t)
+	u, _ := url.Parse("http://example.com/doorbell.mp3")
+	noRetry := RetryPolicy{MaxAttempts: 1}
+	tests := map[string]struct {
+		failLoads  int
+		dropLoads  int
+		idleReason string
+		retry      RetryPolicy
+		want       PlaybackStatus
+		wantLoads  int
+		wantErr    bool
+	}{
+		"finished":       {idleReason: PlaybackFinished, retry: noRetry, want: PlaybackStatus{State: PlaybackFinished}, wantLoads: 1},
+		"retry on fail":  {failLoads: 1, idleReason: PlaybackFinished, retry: RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond}, want: PlaybackStatus{State: PlaybackFinished}, wantLoads: 2},
+		"retry on hang":  {dropLoads: 1, idleReason: PlaybackFinished, retry: RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, AttemptTimeout: 200 * time.Millisecond}, want: PlaybackStatus{State: PlaybackFinished}, wantLoads: 2},
+		"load failed":    {failLoads: 1, idleReason: PlaybackFinished, retry: noRetry, wantLoads: 1, wantErr: true},
+		"playback error": {idleReason: PlaybackError, retry: noRetry, want: PlaybackStatus{State: PlaybackError}, wantLoads: 1, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			receiver := newFakeReceiver(t)
+			defer receiver.Close()
+			receiver.failLoads, receiver.dropLoads, receiver.idleReason = tt.failLoads, tt.dropLoads, tt.idleReason
+			device := &CastDevice{ServiceEntry: receiver.entry("fake-play", "Fake Play")}
+			defer device.Close()
+
+			status, err := device.play(context.Background(), mediaRequest{url: u, contentType: "audio/mpeg", wait: true, retry: tt.retry})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if status.State != tt.want.State {
+				t.Errorf("want state %q, got %q", tt.want.State, status.State)
+			}
+			loads := receiver.loads()
+			if len(loads) != tt.wantLoads {
+				t.Fatalf("want %d loads, got %d", tt.wantLoads, len(loads))
+			}
+			for _, load := range loads {
+				if load.appID != cast.AppMedia || load.item.ContentId != u.String() || load.item.ContentType != "audio/mpeg" {
+					t.Errorf("unexpected load: %+v", load)
+				}
+			}
+		})
+	}
+
+	t.Run("previous status", func(t *testing.T) {
+		receiver := newFakeReceiver(t)
+		defer receiver.Close()
+		// the status of interrupted media is kept until the next media is loaded
+		receiver.keepStatus, receiver.playDuration = true, 200*time.Millisecond
+		receiver.playing(cast.AppMedia, "Default Media Receiver", controllers.MediaItem{ContentId: "http://example.com/radio.mp3"}, 0)
+		receiver.stop("INTERRUPTED")
+		device := &CastDevice{ServiceEntry: receiver.entry("fake-play", "Fake Play")}
+		defer device.Close()
+
+		status, err := device.play(context.Background(), mediaRequest{url: u, contentType: "audio/mpeg", wait: true, retry: noRetry})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != PlaybackFinished || status.Duration < receiver.playDuration/2 {
+			t.Errorf("want finished after the playback, got %q after %s", status.State, status.Duration)
+		}
+	})
+
+	t.Run("not wait", func(t *testing.T) {
+		receiver := newFakeReceiver(t)
+		defer receiver.Close()
+		receiver.playDuration = time.Hour
+		device := &CastDevice{ServiceEntry: receiver.entry("fake-play", "Fake Play")}
+		defer device.Close()
+		if err := device.Play(context.Background(), u); err != nil {
+			t.Fatal(err)
+		}
+		if loads := receiver.loads(); len(loads) != 1 {
+			t.Errorf("want 1 load, got %d", len(loads))
+		}
+	})
+}
+
+func TestNotify(t *testing.T) {
+	useCastReceiver(t)
+	receiver := newFakeReceiver(t)
+	defer receiver.Close()
+	music := controllers.MediaItem{ContentId: "http://example.com/music.mp3", ContentType: "audio/mpeg", StreamType: "BUFFERED"}
+	receiver.playing("ABCDEF12", "Music", music, 42)
+
+	u, _ := url.Parse("http://example.com/message.mp3")
+	opts := Options{
+		TTS:         &fakeTTS{audio: &Audio{URL: u}},
+		Locale:      "en",
+		Volume:      0.6,
+		Devices:     Registry{{ID: "fake-notify", Name: "Fake Notify", Host: "127.0.0.1", Port: receiver.port()}},
+		NoDiscovery: true,
+		Wait:        true,
+		Retry:       RetryPolicy{MaxAttempts: 1},
+	}
+	result, err := Notify(context.Background(), opts, []string{"dinner is ready"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Devices) != 1 {
+		t.Fatalf("want 1 device result, got %d", len(result.Devices))
+	}
+	if got := result.Devices[0]; got.DeviceID != "fake-notify" || got.State != PlaybackFinished {
+		t.Errorf("unexpected result: %+v", got)
+	}
+
+	loads := receiver.loads()
+	if len(loads) != 2 {
+		t.Fatalf("want the message and resumed media, got %d loads", len(loads))
+	}
+	if loads[0].appID != cast.AppMedia || loads[0].item.ContentId != u.String() {
+		t.Errorf("unexpected message load: %+v", loads[0])
+	}
+	if loads[1].appID != "ABCDEF12" || loads[1].item.ContentId != music.ContentId || loads[1].currentTime != 42 || !loads[1].autoplay {
+		t.Errorf("unexpected resumed load: %+v", loads[1])
+	}
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	if want := []float64{0.6, 0.1}; !reflect.DeepEqual(receiver.volumes, want) {
+		t.Errorf("want volumes %v, got %v", want, receiver.volumes)
+	}
+}
+
+func TestNotifyResume(t *testing.T) {
+	useCastReceiver(t)
+	u, _ := url.Parse("http://example.com/message.mp3")
+	music := controllers.MediaItem{ContentId: "http://example.com/music.mp3", ContentType: "audio/mpeg", StreamType: "BUFFERED"}
+	notify := func(receiver *fakeReceiver) {
+		t.Helper()
+		opts := Options{
+			TTS:         &fakeTTS{audio: &Audio{URL: u}},
+			Devices:     Registry{{ID: "fake-resume", Name: "Fake Resume", Host: "127.0.0.1", Port: receiver.port()}},
+			NoDiscovery: true,
+			Wait:        true,
+			Retry:       RetryPolicy{MaxAttempts: 1},
+		}
+		if _, err := Notify(context.Background(), opts, []string{"dinner is ready"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("finished media", func(t *testing.T) {
+		receiver := newFakeReceiver(t)
+		defer receiver.Close()
+		receiver.keepStatus = true
+		notify(receiver)
+		notify(receiver)
+		loads := receiver.loads()
+		if len(loads) != 2 || loads[0].item.ContentId != u.String() || loads[1].item.ContentId != u.String() {
+			t.Errorf("want only messages loaded, got %+v", loads)
+		}
+	})
+
+	t.Run("rejected media", func(t *testing.T) {
+		receiver := newFakeReceiver(t)
+		defer receiver.Close()
+		receiver.rejectApp = "ABCDEF12"
+		receiver.playing("ABCDEF12", "Music", music, 42)
+		notify(receiver)
+		loads := receiver.loads()
+		if len(loads) != 3 {
+			t.Fatalf("want the message, the rejected media and paused media, got %+v", loads)
+		}
+		if last := loads[2]; last.appID != cast.AppMedia || last.item.ContentId != music.ContentId || last.currentTime != 42 || last.autoplay {
+			t.Errorf("media is not left paused: %+v", last)
+		}
+	})
+}
+
+func TestSpeak(t *testing.T) {
+	u, _ := url.Parse("http://example.com/a.mp3")
+	synthErr := errors.New("synthesize failed")
+	tests := map[string]struct {
+		provider *fakeTTS
+		media    *fakeMediaHost
+		wantErr  error
+	}{
+		"url":           {provider: &fakeTTS{audio: &Audio{URL: u}}},
+		"no media host": {provider: &fakeTTS{audio: &Audio{Data: []byte("RIFF")}}, wantErr: errNoAudioURL},
+		"media host":    {provider: &fakeTTS{audio: &Audio{Data: []byte("RIFF"), ContentType: "audio/wav"}}, media: &fakeMediaHost{}},
+		"tts error":     {provider: &fakeTTS{err: synthErr}, wantErr: synthErr},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			device := &CastDevice{}
+			opts := Options{TTS: tt.provider, Locale: "en", Voice: "female"}
+			if tt.media != nil {
+				opts.Media = tt.media
+			}
+			err := device.Speak(context.Background(), "hello", opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+			if len(tt.provider.calls) != 1 || tt.provider.calls[0] != "hello|en|female" {
+				t.Errorf("unexpected synthesize calls: %v", tt.provider.calls)
+			}
+			if tt.media != nil {
+				if len(tt.media.published) != 1 || tt.media.published[0] != "RIFF|audio/wav" {
+					t.Errorf("unexpected published media: %v", tt.media.published)
+				}
+				if len(tt.media.released) != 1 {
+					t.Errorf("published media is not released: %v", tt.media.released)
+				}
+			}
+		})
+	}
+}
+
+type blockingTTS struct {
+	started chan string
+	release chan struct{}
+}
+
+func (b *blockingTTS) Synthesize(_ context.Context, text, _, _ string) (*Audio, error) {
+	b.started <- text
+	<-b.release
+	u, _ := url.Parse("http://example.com/a.mp3")
+	return &Audio{URL: u}, nil
+}
+
+func TestEnqueue(t *testing.T) {
+	provider := &blockingTTS{started: make(chan string, 2), release: make(chan struct{})}
+	device := &CastDevice{ServiceEntry: &mdns.ServiceEntry{InfoFields: []string{"id=queue-test", "fn=Kitchen"}}}
+	opts := Options{TTS: provider, Locale: "en"}
+
+	first := device.Enqueue(context.Background(), "first", opts)
+	second := device.Enqueue(context.Background(), "second", opts)
+	if text := <-provider.started; text != "first" {
+		t.Fatalf("unexpected first item: %s", text)
+	}
+	status := device.Queue()
+	if status.Current != "first" || status.Depth != 1 || status.DeviceName != "Kitchen" {
+		t.Errorf("unexpected queue status: %+v", status)
+	}
+
+	provider.release <- struct{}{}
+	if _, err := first.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if text := <-provider.started; text != "second" {
+		t.Fatalf("unexpected second item: %s", text)
+	}
+	provider.release <- struct{}{}
+	if status, err := second.Wait(); err != nil || status.State != PlaybackFinished {
+		t.Fatalf("unexpected status: %+v, %v", status, err)
+	}
+}
+
+func TestEnqueueMaxQueue(t *testing.T) {
+	provider := &blockingTTS{started: make(chan string, 2), release: make(chan struct{})}
+	device := &CastDevice{ServiceEntry: &mdns.ServiceEntry{InfoFields: []string{"id=max-queue-test", "fn=Kitchen"}}}
+	opts := Options{TTS: provider, Locale: "en", MaxQueue: 1}
+
+	playing := device.Enqueue(context.Background(), "playing", opts)
+	<-provider.started
+	waiting := device.Enqueue(context.Background(), "waiting", opts)
+	if _, err := device.Enqueue(context.Background(), "rejected", opts).Wait(); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("want ErrQueueFull, got %v", err)
+	}
+
+	provider.release <- struct{}{}
+	<-provider.started
+	provider.release <- struct{}{}
+	for _, a := range []*Announcement{playing, waiting} {
+		if _, err := a.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type countingTTS struct {
+	mu      sync.Mutex
+	running int
+	max     int
+}
+
+func (c *countingTTS) Synthesize(_ context.Context, text, _, _ string) (*Audio, error) {
+	c.mu.Lock()
+	c.running++
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	c.mu.Lock()
+	c.running--
+	c.mu.Unlock()
+	u, _ := url.Parse("http://example.com/a.mp3")
+	return &Audio{URL: u}, nil
+}
+
+func TestNotifyDevices(t *testing.T) {
+	provider := &countingTTS{}
+	devices := []*CastDevice{}
+	for _, name := range []string{"Kitchen", "Bedroom", "Living", "Office"} {
+		devices = append(devices, &CastDevice{ServiceEntry: &mdns.ServiceEntry{InfoFields: []string{"id=parallel-" + name, "fn=" + name}}})
+	}
+	opts := Options{TTS: provider, Parallelism: 2}
+	result := notifyDevices(devices, opts, func(device *CastDevice) *Announcement {
+		return device.Enqueue(context.Background(), "hello", opts)
+	})
+	if provider.max != 2 {
+		t.Errorf("want 2 concurrent devices, got %d", provider.max)
+	}
+	if len(result.Devices) != len(devices) {
+		t.Fatalf("unexpected results: %+v", result)
+	}
+	for idx, r := range result.Devices {
+		if r.DeviceName != devices[idx].Name() || r.Err != nil || r.Latency <= 0 {
+			t.Errorf("unexpected result: %+v", r)
+		}
+	}
+	if err := result.Err(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestWaitAnnouncement(t *testing.T) {
+	release := make(chan struct{})
+	playMedia = func(_ *CastDevice, _ context.Context, req mediaRequest) (PlaybackStatus, error) {
+		req.loaded()
+		<-release
+		return PlaybackStatus{State: PlaybackFinished, Duration: time.Second}, nil
+	}
+	defer func() { playMedia = fakePlay }()
+
+	u, _ := url.Parse("http://example.com/a.mp3")
+	device := &CastDevice{ServiceEntry: &mdns.ServiceEntry{InfoFields: []string{"id=wait-test", "fn=Kitchen"}}}
+	a := device.EnqueueAudio(context.Background(), &Audio{URL: u}, Options{NoResume: true})
+	if status, err := wait(a, false); err != nil || status.State != PlaybackLoaded {
+		t.Errorf("want loaded, got %+v, %v", status, err)
+	}
+	close(release)
+	if status, err := wait(a, true); err != nil || status.State != PlaybackFinished || status.Duration != time.Second {
+		t.Errorf("want finished, got %+v, %v", status, err)
+	}
+}
+
+func TestResult(t *testing.T) {
+	result := Result{Devices: []DeviceResult{
+		{DeviceID: "a", DeviceName: "Kitchen", Latency: 1500 * time.Millisecond, State: PlaybackFinished, Duration: time.Second},
+		{DeviceID: "b", DeviceName: "Bedroom", Err: errors.New("timeout")},
+	}}
+	if err := result.Err(); err == nil || err.Error() != "Bedroom: timeout" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if sources := multierr.Split(result.Err()).Sources(); len(sources) != 1 || sources[0] != "Bedroom" {
+		t.Errorf("unexpected failed devices: %v", sources)
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"devices":[{"device_id":"a","device_name":"Kitchen","success":true,"latency_ms":1500,"state":"FINISHED","duration_ms":1000},{"device_id":"b","device_name":"Bedroom","success":false,"error":"timeout","latency_ms":0}]}`
+	if string(b) != want {
+		t.Errorf("want %s, got %s", want, b)
+	}
+}
+
+func TestIsTarget(t *testing.T) {
+	newDevice := func(name, model string) *CastDevice {
+		return &CastDevice{ServiceEntry: &mdns.ServiceEntry{InfoFields: []string{"fn=" + name, "md=" + model}}}
+	}
+	speaker := newDevice("Kitchen", "Google Home")
+	group := newDevice("Whole House", castGroupModel)
+	tests := map[string]struct {
+		device *CastDevice
+		names  []string
+		filter ModelFilter
+		want   bool
+	}{
+		"speaker of all":           {device: speaker, want: true},
+		"group of all":             {device: group, want: false},
+		"named group":              {device: group, names: []string{"Whole House"}, want: true},
+		"named group out of model": {device: group, names: []string{"Whole House"}, filter: ModelFilter{Include: []string{"Nest*"}}, want: true},
+		"other name":               {device: speaker, names: []string{"Whole House"}, want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.device.isTarget(tt.names, tt.filter); got != tt.want {
+				t.Errorf("want %t, got %t", tt.want, got)
+			}
+		})
+	}
 }
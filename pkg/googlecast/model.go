@@ -0,0 +1,59 @@
+package googlecast
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultModels are model patterns of Google Home and Nest speakers
+var DefaultModels = []string{"Google*", "Nest*"}
+
+// ModelFilter selects devices by the model name advertised in the md field.
+// Patterns are case-insensitive shell patterns such as "Google*".
+type ModelFilter struct {
+	// Include is a list of accepted models. Empty uses DefaultModels
+	Include []string
+	// Exclude is a list of rejected models. It has priority over Include
+	Exclude []string
+	// Any accepts any cast-capable receivers except excluded models
+	Any bool
+}
+
+// Validate checks syntax of patterns
+func (f ModelFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid model pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the filter accepts the model
+func (f ModelFilter) Match(model string) bool {
+	if model == "" {
+		return false
+	}
+	if matchAny(f.Exclude, model) {
+		return false
+	}
+	if f.Any {
+		return true
+	}
+	include := f.Include
+	if len(include) == 0 {
+		include = DefaultModels
+	}
+	return matchAny(include, model)
+}
+
+func matchAny(patterns []string, model string) bool {
+	model = strings.ToLower(model)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), model); ok {
+			return true
+		}
+	}
+	return false
+}
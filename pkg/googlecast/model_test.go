@@ -0,0 +1,31 @@
+package googlecast
+
+import "testing"
+
+func TestModelFilter(t *testing.T) {
+	tests := map[string]struct {
+		filter ModelFilter
+		model  string
+		want   bool
+	}{
+		"default google":     {model: "Google Home Mini", want: true},
+		"default nest":       {model: "Nest Audio", want: true},
+		"default chromecast": {model: "Chromecast Audio", want: false},
+		"no model":           {model: "", want: false},
+		"include":            {filter: ModelFilter{Include: []string{"chromecast*"}}, model: "Chromecast Audio", want: true},
+		"include only":       {filter: ModelFilter{Include: []string{"chromecast*"}}, model: "Google Home", want: false},
+		"exclude":            {filter: ModelFilter{Exclude: []string{"*hub*"}}, model: "Google Nest Hub", want: false},
+		"any":                {filter: ModelFilter{Any: true}, model: "JBL Link 10", want: true},
+		"any with exclude":   {filter: ModelFilter{Any: true, Exclude: []string{"JBL*"}}, model: "JBL Link 10", want: false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := tt.filter.Match(tt.model); got != tt.want {
+				t.Errorf("want %t, got %t", tt.want, got)
+			}
+		})
+	}
+	if err := (ModelFilter{Include: []string{"[Google"}}).Validate(); err == nil {
+		t.Error("invalid pattern is accepted")
+	}
+}
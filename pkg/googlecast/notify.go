@@ -2,10 +2,18 @@ package googlecast
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"log"
+	"sync"
 	"time"
+
+	"github.com/tomoyamachi/notifyhome/pkg/multierr"
 )
 
+// DefaultParallelism is a default number of devices which are notified concurrently
+const DefaultParallelism = 4
+
 var notifyAfter = time.Now()
 
 func SetNotifyAfter(target time.Time) {
@@ -16,36 +24,298 @@ func notifiable() bool {
 	return notifyAfter.Before(time.Now())
 }
 
-func Notify(ctx context.Context, deviceCnt int, friendlyName, locale string, msgs []string) error {
+// Options is settings to deliver notifications
+type Options struct {
+	// DeviceCount is a maximum number of detected devices. It is ignored when target devices are named.
+	DeviceCount int
+	// FriendlyName is a target device name. Empty notifies from all found devices
+	FriendlyName string
+	// DeviceNames are target device names in addition to FriendlyName
+	DeviceNames []string
+	// CastGroup is a target Cast speaker group name. All members of the speaker group play in sync
+	CastGroup string
+	// Group is a target group name in Groups. Members are notified in addition to FriendlyName
+	Group string
+	// Groups maps group names to device names
+	Groups map[string][]string
+	Locale string
+	Voice  string
+	// Volume is a volume level between 0 and 1 during announcements. The previous level is restored afterwards.
+	// Zero keeps the current level.
+	Volume float64
+	// TTS converts messages to speech. Default uses TranslateTTS
+	TTS TTSProvider
+	// Media hosts audio data generated by TTS
+	Media MediaHost
+	// Devices are static devices which are used without mDNS discovery
+	Devices Registry
+	// NoDiscovery disables mDNS discovery when no static device matches
+	NoDiscovery bool
+	// Models selects discovered devices by models
+	Models ModelFilter
+	// Priority is a priority of the notification such as "high", which selects a chime
+	Priority string
+	// Chimes maps priorities to sounds played before messages. The chime of the empty priority is a default.
+	Chimes map[string]*Audio
+	// NoResume does not resume media which was playing before announcements
+	NoResume bool
+	// Retry is a retry policy of connecting and loading media on each device
+	Retry RetryPolicy
+	// Wait waits until devices finish playing notifications.
+	// Otherwise notifications return after devices accepted the last media.
+	Wait bool
+	// loaded is called after the device accepted the last media of an announcement
+	loaded func()
+	// Parallelism is a maximum number of devices which are notified concurrently. Default is DefaultParallelism.
+	Parallelism int
+	// MaxQueue is a maximum number of waiting announcements on each device.
+	// Announcements over the limit fail with ErrQueueFull. Zero is unlimited.
+	MaxQueue int
+}
+
+type (
+	// Result is a result of a notification on each device
+	Result struct {
+		Devices []DeviceResult `json:"devices"`
+	}
+
+	// DeviceResult is a result of a notification on a device
+	DeviceResult struct {
+		DeviceID   string
+		DeviceName string
+		// Err is nil if the device played the notification
+		Err error
+		// Latency is a duration until the device finished playing or accepted the media, including waiting in the queue
+		Latency time.Duration
+		// State is a final state of the playback, or PlaybackLoaded if it was not waited
+		State string
+		// Duration is a duration of the playback if it was waited
+		Duration time.Duration
+	}
+)
+
+// Err returns errors of failed devices as multierr.Errors, which sources are device names.
+// It returns nil if all devices succeeded.
+func (r Result) Err() error {
+	var err error
+	for _, device := range r.Devices {
+		err = multierr.Append(err, multierr.Wrap(device.DeviceName, device.Err))
+	}
+	return err
+}
+
+// MarshalJSON encodes the error as a message, and durations in milliseconds
+func (r DeviceResult) MarshalJSON() ([]byte, error) {
+	v := struct {
+		DeviceID   string `json:"device_id"`
+		DeviceName string `json:"device_name"`
+		Success    bool   `json:"success"`
+		Error      string `json:"error,omitempty"`
+		LatencyMS  int64  `json:"latency_ms"`
+		State      string `json:"state,omitempty"`
+		DurationMS int64  `json:"duration_ms,omitempty"`
+	}{
+		DeviceID:   r.DeviceID,
+		DeviceName: r.DeviceName,
+		Success:    r.Err == nil,
+		LatencyMS:  r.Latency.Milliseconds(),
+		State:      r.State,
+		DurationMS: r.Duration.Milliseconds(),
+	}
+	if r.Err != nil {
+		v.Error = r.Err.Error()
+	}
+	return json.Marshal(v)
+}
+
+// Notify speaks messages on target devices concurrently, and returns a result of each device.
+// The error is not nil if finding devices failed or any device failed.
+func Notify(ctx context.Context, opts Options, msgs []string) (Result, error) {
 	if !notifiable() {
 		log.Printf("notify will restart after %s", notifyAfter.Format("2006/01/02 15:04"))
-		return nil
+		return Result{}, nil
 	}
 
 	if len(msgs) == 0 {
-		return nil
+		return Result{}, nil
 	}
-	devices := LookupAndConnect(ctx, deviceCnt, friendlyName)
+	devices, release, err := findDevices(opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer release()
 	if len(devices) == 0 {
 		log.Print("no device found.")
-		return nil
+		return Result{}, nil
 	}
-	errs := []error{}
-	for _, device := range devices {
-		totalMsg := ""
-		for _, msg := range msgs {
-			totalMsg += msg
+	totalMsg := joinMessages(msgs, opts.Locale)
+	if len(totalMsg) == 0 {
+		return Result{}, nil
+	}
+	result := notifyDevices(devices, opts, func(device *CastDevice) *Announcement {
+		return device.Enqueue(ctx, totalMsg, opts)
+	})
+	return result, result.Err()
+}
+
+// PlayAudio plays audio on target devices concurrently without chimes, and returns a result of each device.
+// The error is not nil if finding devices failed or any device failed.
+func PlayAudio(ctx context.Context, opts Options, audio *Audio) (Result, error) {
+	if !notifiable() {
+		log.Printf("notify will restart after %s", notifyAfter.Format("2006/01/02 15:04"))
+		return Result{}, nil
+	}
+	if audio.URL == nil && opts.Media == nil {
+		return Result{}, errNoAudioURL
+	}
+	devices, release, err := findDevices(opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer release()
+	if len(devices) == 0 {
+		log.Print("no device found.")
+		return Result{}, nil
+	}
+	result := notifyDevices(devices, opts, func(device *CastDevice) *Announcement {
+		return device.EnqueueAudio(ctx, audio, opts)
+	})
+	return result, result.Err()
+}
+
+// notifyDevices enqueues announcements on devices by a bounded number of workers.
+// Queues serialize announcements per device, and workers wait for them concurrently.
+func notifyDevices(devices []*CastDevice, opts Options, enqueue func(device *CastDevice) *Announcement) Result {
+	workers := opts.Parallelism
+	if workers <= 0 {
+		workers = DefaultParallelism
+	}
+	if workers > len(devices) {
+		workers = len(devices)
+	}
+	results := make([]DeviceResult, len(devices))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				device := devices[idx]
+				start := time.Now()
+				status, err := wait(enqueue(device), opts.Wait)
+				results[idx] = DeviceResult{
+					DeviceID:   device.ID(),
+					DeviceName: device.Name(),
+					Err:        err,
+					Latency:    time.Since(start),
+					State:      status.State,
+					Duration:   status.Duration,
+				}
+			}
+		}()
+	}
+	for idx := range devices {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+	return Result{Devices: results}
+}
+
+// wait waits until the announcement finishes, or the device accepted it unless finish
+func wait(a *Announcement, finish bool) (PlaybackStatus, error) {
+	if !finish {
+		select {
+		case <-a.Loaded():
+			select {
+			case <-a.Done():
+			default:
+				return PlaybackStatus{State: PlaybackLoaded}, nil
+			}
+		case <-a.Done():
+		}
+	}
+	return a.Wait()
+}
+
+// targetNames returns names of target devices. Empty means all devices.
+func (o Options) targetNames() ([]string, error) {
+	names := []string{}
+	if o.FriendlyName != "" {
+		names = append(names, o.FriendlyName)
+	}
+	names = append(names, o.DeviceNames...)
+	if o.CastGroup != "" {
+		names = append(names, o.CastGroup)
+	}
+	if o.Group != "" {
+		members, ok := o.Groups[o.Group]
+		if !ok {
+			return nil, fmt.Errorf("unknown device group: %s", o.Group)
 		}
+		names = append(names, members...)
+	}
+	return names, nil
+}
 
-		if len(totalMsg) > 0 {
-			if err := device.Speak(ctx, totalMsg, locale); err != nil {
-				errs = append(errs, err)
+// findDevices returns static devices, and cached devices discovered by mDNS for names which are not static.
+// release closes connections which are not cached.
+func findDevices(opts Options) ([]*CastDevice, func(), error) {
+	names, err := opts.targetNames()
+	if err != nil {
+		return nil, nil, err
+	}
+	devices := opts.Devices.Resolve(names...)
+	release := func() {
+		for _, device := range devices {
+			device.Close()
+		}
+	}
+	if opts.NoDiscovery {
+		return devices, release, nil
+	}
+
+	max := opts.DeviceCount
+	if len(names) == 0 {
+		// static devices are all targets
+		if len(devices) > 0 {
+			return devices, release, nil
+		}
+	} else {
+		max = 0
+		missing := []string{}
+		for _, name := range names {
+			if !containsName(devices, name) {
+				missing = append(missing, name)
 			}
 		}
+		if len(missing) == 0 {
+			return devices, release, nil
+		}
+		names = missing
 	}
-	// TODO: fix: Only return first error
-	for _, err := range errs {
-		return err
+	return append(devices, cachedDevices(max, names, opts.Models)...), release, nil
+}
+
+// matchName reports whether the name is one of names. Empty names match all names.
+func matchName(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsName(devices []*CastDevice, name string) bool {
+	for _, device := range devices {
+		if device.Name() == name {
+			return true
+		}
 	}
-	return nil
+	return false
 }
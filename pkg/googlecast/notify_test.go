@@ -0,0 +1,34 @@
+package googlecast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTargetNames(t *testing.T) {
+	groups := map[string][]string{"downstairs": {"Living Room", "Kitchen"}}
+	tests := map[string]struct {
+		opts    Options
+		want    []string
+		wantErr bool
+	}{
+		"all":       {opts: Options{Groups: groups}, want: []string{}},
+		"device":    {opts: Options{FriendlyName: "Bedroom", Groups: groups}, want: []string{"Bedroom"}},
+		"group":     {opts: Options{Group: "downstairs", Groups: groups}, want: []string{"Living Room", "Kitchen"}},
+		"devices":   {opts: Options{FriendlyName: "Bedroom", DeviceNames: []string{"Office", "Garage"}}, want: []string{"Bedroom", "Office", "Garage"}},
+		"both":      {opts: Options{FriendlyName: "Bedroom", Group: "downstairs", Groups: groups}, want: []string{"Bedroom", "Living Room", "Kitchen"}},
+		"unknown":   {opts: Options{Group: "upstairs", Groups: groups}, wantErr: true},
+		"no groups": {opts: Options{Group: "downstairs"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := tt.opts.targetNames()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
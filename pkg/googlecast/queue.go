@@ -0,0 +1,194 @@
+package googlecast
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// ErrQueueFull is an error of an announcement which is rejected by Options.MaxQueue
+var ErrQueueFull = errors.New("announcement queue is full")
+
+type (
+	// QueueStatus is a state of an announcement queue of a device
+	QueueStatus struct {
+		DeviceID   string `json:"device_id"`
+		DeviceName string `json:"device_name"`
+		// Depth is a number of waiting announcements
+		Depth int `json:"depth"`
+		// Current is a text being played. Empty if the device is idle.
+		Current string `json:"current"`
+	}
+
+	// queue serializes announcements on a device
+	queue struct {
+		mu      sync.Mutex
+		items   []*queueItem
+		current *queueItem
+		running bool
+	}
+
+	queueItem struct {
+		ctx    context.Context
+		device *CastDevice
+		// text is a message to speak, or a description of the audio
+		text string
+		// audio is played instead of speaking the text if it is not nil
+		audio        *Audio
+		opts         Options
+		announcement *Announcement
+	}
+
+	// Announcement is a queued announcement on a device
+	Announcement struct {
+		loadedOnce sync.Once
+		loaded     chan struct{}
+		done       chan struct{}
+		status     PlaybackStatus
+		err        error
+	}
+)
+
+var (
+	queuesMu sync.Mutex
+	queues   = map[string]*queue{}
+)
+
+// Enqueue adds a text to the announcement queue of the device
+func (g *CastDevice) Enqueue(ctx context.Context, text string, opts Options) *Announcement {
+	return g.enqueue(&queueItem{ctx: ctx, device: g, text: text, opts: opts})
+}
+
+// EnqueueAudio adds audio to the announcement queue of the device
+func (g *CastDevice) EnqueueAudio(ctx context.Context, audio *Audio, opts Options) *Announcement {
+	return g.enqueue(&queueItem{ctx: ctx, device: g, text: audio.String(), audio: audio, opts: opts})
+}
+
+func (g *CastDevice) enqueue(item *queueItem) *Announcement {
+	a := &Announcement{loaded: make(chan struct{}), done: make(chan struct{})}
+	item.announcement = a
+	item.opts.loaded = a.markLoaded
+	q := deviceQueue(g.ID())
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if max := item.opts.MaxQueue; max > 0 && len(q.items) >= max {
+		a.finish(PlaybackStatus{}, ErrQueueFull)
+		return a
+	}
+	q.items = append(q.items, item)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+	return a
+}
+
+// Loaded is closed after the device accepted the last media of the announcement, or the announcement finished.
+// Audio hosted by the media host, and announcements which restore the volume or interrupted media,
+// are accepted after the playback, since they need this process until the playback finishes.
+func (a *Announcement) Loaded() <-chan struct{} {
+	return a.loaded
+}
+
+// Done is closed after the playback of the announcement finished
+func (a *Announcement) Done() <-chan struct{} {
+	return a.done
+}
+
+// Wait blocks until the playback of the announcement finishes, and returns the final status
+func (a *Announcement) Wait() (PlaybackStatus, error) {
+	<-a.done
+	return a.status, a.err
+}
+
+func (a *Announcement) markLoaded() {
+	a.loadedOnce.Do(func() { close(a.loaded) })
+}
+
+func (a *Announcement) finish(status PlaybackStatus, err error) {
+	a.status, a.err = status, err
+	a.markLoaded()
+	close(a.done)
+}
+
+// Queue returns a state of the announcement queue of the device
+func (g *CastDevice) Queue() QueueStatus {
+	return deviceQueue(g.ID()).status(g.ID(), g.Name())
+}
+
+// Queues returns states of announcement queues of all devices which have been notified
+func Queues() []QueueStatus {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	statuses := make([]QueueStatus, 0, len(queues))
+	for id, q := range queues {
+		name := ""
+		q.mu.Lock()
+		if q.current != nil {
+			name = q.current.device.Name()
+		} else if len(q.items) > 0 {
+			name = q.items[0].device.Name()
+		}
+		q.mu.Unlock()
+		statuses = append(statuses, q.status(id, name))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].DeviceID < statuses[j].DeviceID })
+	return statuses
+}
+
+func deviceQueue(id string) *queue {
+	queuesMu.Lock()
+	defer queuesMu.Unlock()
+	q, ok := queues[id]
+	if !ok {
+		q = &queue{}
+		queues[id] = q
+	}
+	return q
+}
+
+// run plays queued items one by one until the queue becomes empty
+func (q *queue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.current = nil
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		item := q.items[0]
+		q.items = q.items[1:]
+		q.current = item
+		q.mu.Unlock()
+
+		if err := item.ctx.Err(); err != nil {
+			item.announcement.finish(PlaybackStatus{}, err)
+			continue
+		}
+		// waits the last chunk as well, so that the next item does not cut it off
+		status, err := item.play()
+		if err != nil {
+			discovered.invalidate(item.device)
+		}
+		item.announcement.finish(status, err)
+	}
+}
+
+func (item *queueItem) play() (PlaybackStatus, error) {
+	if item.audio != nil {
+		return item.device.playAnnouncement(item.ctx, item.audio, item.opts, true)
+	}
+	return item.device.speakChunks(item.ctx, item.text, item.opts, true)
+}
+
+func (q *queue) status(id, name string) QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := QueueStatus{DeviceID: id, DeviceName: name, Depth: len(q.items)}
+	if q.current != nil {
+		status.Current = q.current.text
+	}
+	return status
+}
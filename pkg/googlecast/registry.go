@@ -0,0 +1,187 @@
+package googlecast
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/mdns"
+)
+
+const (
+	registryFile = "devices.json"
+	defaultPort  = 8009
+)
+
+type (
+	// StaticDevice is a device declared by name and address, which does not need mDNS discovery
+	StaticDevice struct {
+		ID   string `json:"id,omitempty"`
+		Name string `json:"name"`
+		Host string `json:"host"`
+		Port int    `json:"port,omitempty"`
+		// Model is an advertised model name. "Google Cast Group" declares a speaker group.
+		Model string `json:"model,omitempty"`
+	}
+
+	// Registry is a list of static devices
+	Registry []StaticDevice
+)
+
+// ParseStaticDevice parses a device declaration formatted as name=host[:port]
+func ParseStaticDevice(s string) (StaticDevice, error) {
+	idx := strings.LastIndex(s, "=")
+	if idx <= 0 || idx == len(s)-1 {
+		return StaticDevice{}, fmt.Errorf("invalid device %q: format is name=host[:port]", s)
+	}
+	d := StaticDevice{Name: s[:idx], Host: s[idx+1:]}
+	if host, port, err := net.SplitHostPort(d.Host); err == nil {
+		if d.Port, err = strconv.Atoi(port); err != nil {
+			return StaticDevice{}, fmt.Errorf("invalid port of device %q: %w", s, err)
+		}
+		d.Host = host
+	}
+	return d, nil
+}
+
+// LoadRegistry loads static devices from file. Returns empty registry if the file does not exist.
+func LoadRegistry(path string) (Registry, error) {
+	return readRegistry(path + registryFile)
+}
+
+// Save saves static devices to a file
+func (r Registry) Save(path string) error {
+	fmt.Printf("Saving device file to: %s\n", path+registryFile)
+	return r.write(path + registryFile)
+}
+
+func readRegistry(fileName string) (r Registry, err error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Registry{}, nil
+		}
+		return nil, fmt.Errorf("Open %s: %w", fileName, err)
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&r); err != nil {
+		return nil, fmt.Errorf("Decode devices: %w", err)
+	}
+	return r, nil
+}
+
+func (r Registry) write(fileName string) error {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Save devices: %w", err)
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// Merge returns a registry which devices are updated or added by the name
+func (r Registry) Merge(devices Registry) Registry {
+	merged := append(Registry{}, r...)
+	for _, device := range devices {
+		found := false
+		for idx := range merged {
+			if merged[idx].Name == device.Name {
+				merged[idx] = device
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, device)
+		}
+	}
+	return merged
+}
+
+// Resolve returns registered devices which have one of the names. They are connected on the first playback.
+// No names returns all devices except speaker groups.
+func (r Registry) Resolve(names ...string) []*CastDevice {
+	return r.resolve(func(d StaticDevice) bool {
+		return matchName(names, d.Name) && (len(names) > 0 || d.Model != castGroupModel)
+	})
+}
+
+func (r Registry) resolve(match func(d StaticDevice) bool) []*CastDevice {
+	devices := []*CastDevice{}
+	for _, d := range r {
+		if !match(d) {
+			continue
+		}
+		device, err := d.resolve()
+		if err != nil {
+			log.Printf("[ERROR] Failed to resolve %s: %s", d.Name, err)
+			continue
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// resolve returns an unconnected device of the declaration.
+// A declaration without ID takes the UUID of a discovered device on the same address,
+// so that both share the announcement queue of the device.
+func (d StaticDevice) resolve() (*CastDevice, error) {
+	port := d.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	addr, err := net.ResolveIPAddr("ip4", d.Host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", d.Host, err)
+	}
+	entry := &mdns.ServiceEntry{
+		Name:       d.Name,
+		Host:       d.Host,
+		AddrV4:     addr.IP,
+		Addr:       addr.IP,
+		Port:       port,
+		InfoFields: []string{fmt.Sprintf("%s=%s", friendryNamePrefix, d.Name)},
+	}
+	id := d.ID
+	if id == "" {
+		id = discovered.idOf(addr.IP, port)
+	}
+	if id != "" {
+		entry.InfoFields = append(entry.InfoFields, fmt.Sprintf("%s=%s", idPrefix, id))
+	}
+	if d.Model != "" {
+		entry.InfoFields = append(entry.InfoFields, fmt.Sprintf("%s=%s", modelTypePrefix, d.Model))
+	}
+	return &CastDevice{ServiceEntry: entry}, nil
+}
+
+// staticDevice returns a declaration of the discovered device
+func (g *CastDevice) staticDevice() StaticDevice {
+	return StaticDevice{ID: g.info(idPrefix), Name: g.Name(), Host: g.AddrV4.String(), Port: g.Port, Model: g.info(modelTypePrefix)}
+}
+
+// Discover discovers devices which the filter accepts and speaker groups by mDNS, and returns their declarations
+func Discover(ctx context.Context, max int, filter ModelFilter) Registry {
+	devices := lookup(func(entry *mdns.ServiceEntry) *CastDevice {
+		device := &CastDevice{ServiceEntry: entry}
+		if !device.IsGroup() && !filter.Match(device.info(modelTypePrefix)) {
+			return nil
+		}
+		return device
+	})
+	if max > 0 && len(devices) > max {
+		devices = devices[:max]
+	}
+	r := make(Registry, 0, len(devices))
+	for _, device := range devices {
+		r = append(r, device.staticDevice())
+	}
+	return r
+}
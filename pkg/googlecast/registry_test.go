@@ -0,0 +1,75 @@
+package googlecast
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/mdns"
+)
+
+func TestParseStaticDevice(t *testing.T) {
+	tests := map[string]struct {
+		in      string
+		want    StaticDevice
+		wantErr bool
+	}{
+		"with port":    {in: "Living Room=192.168.1.10:8009", want: StaticDevice{Name: "Living Room", Host: "192.168.1.10", Port: 8009}},
+		"without port": {in: "Kitchen=kitchen.local", want: StaticDevice{Name: "Kitchen", Host: "kitchen.local"}},
+		"no name":      {in: "=192.168.1.10", wantErr: true},
+		"no host":      {in: "Kitchen=", wantErr: true},
+		"invalid port": {in: "Kitchen=192.168.1.10:abc", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseStaticDevice(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRegistryMerge(t *testing.T) {
+	r := Registry{{Name: "Kitchen", Host: "192.168.1.10"}, {Name: "Garage", Host: "10.0.0.5"}}
+	got := r.Merge(Registry{{Name: "Kitchen", Host: "192.168.1.11", Port: 8009}, {Name: "Bedroom", Host: "192.168.1.12", Port: 8009}})
+	want := Registry{
+		{Name: "Kitchen", Host: "192.168.1.11", Port: 8009},
+		{Name: "Garage", Host: "10.0.0.5"},
+		{Name: "Bedroom", Host: "192.168.1.12", Port: 8009},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestStaticDeviceResolve(t *testing.T) {
+	ip := net.IPv4(127, 0, 0, 1)
+	discovered.replace([]*CastDevice{{ServiceEntry: &mdns.ServiceEntry{AddrV4: ip, Port: 8009, InfoFields: []string{"id=living-uuid", "fn=Living Room"}}}})
+	defer discovered.clear()
+	tests := map[string]struct {
+		device StaticDevice
+		want   string
+	}{
+		"declared id":   {device: StaticDevice{ID: "declared-uuid", Name: "Living Room", Host: "127.0.0.1"}, want: "declared-uuid"},
+		"discovered id": {device: StaticDevice{Name: "Living Room", Host: "127.0.0.1", Port: 8009}, want: "living-uuid"},
+		"address":       {device: StaticDevice{Name: "Kitchen", Host: "127.0.0.1", Port: 8010}, want: "127.0.0.1:8010"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			device, err := tt.device.resolve()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if device.ID() != tt.want {
+				t.Errorf("want ID %s, got %s", tt.want, device.ID())
+			}
+			if device.castClient() != nil {
+				t.Error("static devices must be connected on playback")
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package googlecast
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	cast "github.com/barnybug/go-cast"
+	"github.com/barnybug/go-cast/controllers"
+	castnet "github.com/barnybug/go-cast/net"
+)
+
+const (
+	mediaNamespace     = "urn:x-cast:com.google.cast.media"
+	playerStatePlaying = "PLAYING"
+	playerStatePaused  = "PAUSED"
+	playerStateBuffer  = "BUFFERING"
+	streamTypeLive     = "LIVE"
+)
+
+// playback is media which was playing on a device before an announcement
+type playback struct {
+	appID    string
+	appName  string
+	media    controllers.MediaItem
+	position float64
+	playing  bool
+}
+
+// interruptedPlayback returns media loaded on the device, or nil if the device does not have media.
+// Failures are only logged, since the announcement should be played anyway.
+func (g *CastDevice) interruptedPlayback(ctx context.Context) *playback {
+	p, err := g.capturePlayback(ctx)
+	if err != nil {
+		log.Printf("[WARN] get media status of %s: %s", g.Name(), err)
+		return nil
+	}
+	return p
+}
+
+func (g *CastDevice) capturePlayback(ctx context.Context) (*playback, error) {
+	rec, err := g.connected(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status, err := receiverStatus(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	// idle screens do not support media
+	app := status.GetSessionByNamespace(mediaNamespace)
+	if app == nil || app.AppID == nil || app.TransportId == nil {
+		return nil, nil
+	}
+	session, err := g.openMedia(ctx, *app.TransportId)
+	if err != nil {
+		return nil, err
+	}
+	defer session.close()
+	statuses, err := session.status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statuses {
+		if s.Media == nil || s.Media.ContentId == "" {
+			continue
+		}
+		// idle media finished or stopped, and is not interrupted
+		switch s.PlayerState {
+		case playerStatePlaying, playerStatePaused, playerStateBuffer:
+		default:
+			continue
+		}
+		p := &playback{
+			appID:    *app.AppID,
+			media:    controllers.MediaItem{ContentId: s.Media.ContentId, ContentType: s.Media.ContentType, StreamType: s.Media.StreamType},
+			position: s.CurrentTime,
+			playing:  s.PlayerState == playerStatePlaying || s.PlayerState == playerStateBuffer,
+		}
+		if app.DisplayName != nil {
+			p.appName = *app.DisplayName
+		}
+		log.Printf("[INFO] %s interrupts %s at %.0fs", g.Name(), p.appName, p.position)
+		return p, nil
+	}
+	return nil, nil
+}
+
+// resume relaunches the app which was interrupted, and loads its media at the previous position.
+// If the app does not accept the media, such as streaming services which need credentials,
+// media of an http(s) URL is left paused on the default media receiver, and other apps are left launched.
+func (g *CastDevice) resume(p *playback) {
+	ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+	defer cancel()
+	err := g.restorePlayback(ctx, p.appID, p, p.playing)
+	if err == nil {
+		return
+	}
+	log.Printf("[WARN] resume %s on %s: %s", p.appName, g.Name(), err)
+	if p.appID == cast.AppMedia || !p.fetchable() {
+		return
+	}
+	log.Printf("[INFO] leave %s paused on the default media receiver of %s", p.media.ContentId, g.Name())
+	if err := g.restorePlayback(ctx, cast.AppMedia, p, false); err != nil {
+		log.Printf("[WARN] load %s paused on %s: %s", p.media.ContentId, g.Name(), err)
+	}
+}
+
+// fetchable reports whether the default media receiver can load the media
+func (p *playback) fetchable() bool {
+	return strings.HasPrefix(p.media.ContentId, "http://") || strings.HasPrefix(p.media.ContentId, "https://")
+}
+
+// restorePlayback launches the app, and loads the media at the previous position
+func (g *CastDevice) restorePlayback(ctx context.Context, appID string, p *playback, autoplay bool) error {
+	rec, err := g.connected(ctx)
+	if err != nil {
+		return err
+	}
+	app, err := launchApp(ctx, rec, appID)
+	if err != nil {
+		return err
+	}
+	session, err := g.openMedia(ctx, *app.TransportId)
+	if err != nil {
+		return err
+	}
+	defer session.close()
+	position := int(p.position)
+	if p.media.StreamType == streamTypeLive {
+		position = 0
+	}
+	if _, err := session.loadMedia(ctx, p.media, position, autoplay); err != nil {
+		return err
+	}
+	return nil
+}
+
+// launchApp returns a session of the app, and launches the app if it is not running
+func launchApp(ctx context.Context, rec *castChannel, appID string) (*controllers.ApplicationSession, error) {
+	status, err := receiverStatus(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	if app := status.GetSessionByAppId(appID); app != nil && app.TransportId != nil {
+		return app, nil
+	}
+	message, err := rec.request(ctx, "LAUNCH", func(headers castnet.PayloadHeaders) interface{} {
+		return &controllers.LaunchRequest{PayloadHeaders: headers, AppId: appID}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status, err = parseReceiverStatus(message); err != nil {
+		return nil, err
+	}
+	app := status.GetSessionByAppId(appID)
+	if app == nil || app.TransportId == nil {
+		return nil, fmt.Errorf("app %s is not launched", appID)
+	}
+	return app, nil
+}
@@ -0,0 +1,125 @@
+package googlecast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// DefaultRetryPolicy retries a playback 3 times after about 1, 2 and 4 seconds.
+// Each attempt is given up after 20 seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Second,
+	MaxBackoff:     8 * time.Second,
+	Jitter:         0.2,
+	AttemptTimeout: 20 * time.Second,
+}
+
+// RetryPolicy is settings to retry connecting and loading media on a device.
+// Zero values of fields are replaced by DefaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is a maximum number of attempts including the first one. 1 disables retries.
+	MaxAttempts int
+	// InitialBackoff is a wait before the first retry. The wait doubles on each retry.
+	InitialBackoff time.Duration
+	// MaxBackoff is a maximum wait between attempts
+	MaxBackoff time.Duration
+	// Jitter is a ratio of the wait which is randomized, between 0 and 1
+	Jitter float64
+	// AttemptTimeout is a maximum duration of each attempt, so that a device which stopped responding is connected again
+	AttemptTimeout time.Duration
+}
+
+// permanentError is an error which retries do not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// permanent marks an error not to be retried
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.Jitter <= 0 || p.Jitter > 1 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	if p.AttemptTimeout <= 0 {
+		p.AttemptTimeout = DefaultRetryPolicy.AttemptTimeout
+	}
+	return p
+}
+
+// backoff returns a wait before the retry of the attempt, which starts from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	jitter := time.Duration(float64(d) * p.Jitter)
+	if jitter <= 0 {
+		return d
+	}
+	return d - jitter + time.Duration(rand.Int63n(int64(2*jitter)))
+}
+
+// do calls op until it succeeds, returns a permanent error, or attempts run out.
+// attempt starts from 0, so that op can recover from the previous failure on retries.
+// ctx of op is canceled after AttemptTimeout, and the timeout is retried.
+func (p RetryPolicy) do(ctx context.Context, op func(ctx context.Context, attempt int) error) error {
+	p = p.withDefaults()
+	var err error
+	for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			wait := p.backoff(attempt)
+			log.Printf("[WARN] retry in %s: %s", wait.Round(time.Millisecond), err)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("%w (last error: %s)", ctx.Err(), err)
+			}
+		}
+		if err = p.attempt(ctx, attempt, op); err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w (last error: %s)", ctx.Err(), err)
+		}
+	}
+	return fmt.Errorf("failed after %d attempts: %w", p.MaxAttempts, err)
+}
+
+// attempt calls op with ctx which is canceled after AttemptTimeout
+func (p RetryPolicy) attempt(ctx context.Context, attempt int, op func(ctx context.Context, attempt int) error) error {
+	ctx, cancel := context.WithTimeout(ctx, p.AttemptTimeout)
+	defer cancel()
+	return op(ctx, attempt)
+}
@@ -0,0 +1,91 @@
+package googlecast
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	errTemporary := errors.New("temporary")
+	tests := map[string]struct {
+		errs     []error
+		wantErr  error
+		wantCall int
+	}{
+		"success":          {errs: []error{nil}, wantCall: 1},
+		"retry":            {errs: []error{errTemporary, errTemporary, nil}, wantCall: 3},
+		"attempts run out": {errs: []error{errTemporary, errTemporary, errTemporary}, wantErr: errTemporary, wantCall: 3},
+		"permanent":        {errs: []error{permanent(errNoAddress)}, wantErr: errNoAddress, wantCall: 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			err := policy.do(context.Background(), func(_ context.Context, attempt int) error {
+				if attempt != calls {
+					t.Errorf("want attempt %d, got %d", calls, attempt)
+				}
+				calls++
+				return tt.errs[attempt]
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+			if calls != tt.wantCall {
+				t.Errorf("want %d calls, got %d", tt.wantCall, calls)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour}
+	err := policy.do(ctx, func(context.Context, int) error {
+		cancel()
+		return errors.New("temporary")
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want canceled, got %v", err)
+	}
+}
+
+func TestRetryPolicyAttemptTimeout(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond, AttemptTimeout: 10 * time.Millisecond}
+	var errs []error
+	err := policy.do(context.Background(), func(ctx context.Context, attempt int) error {
+		if attempt > 0 {
+			return nil
+		}
+		// a request to a device which stopped responding
+		<-ctx.Done()
+		errs = append(errs, ctx.Err())
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Errorf("timeout is not retried: %v", err)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("want a timeout of the first attempt, got %v", errs)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Jitter: 0.5}.withDefaults()
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := policy.backoff(attempt); got < want/2 || got >= want*3/2 {
+			t.Errorf("attempt %d: want %s with jitter, got %s", attempt, want, got)
+		}
+	}
+}
+
+func TestPlayWithoutAddress(t *testing.T) {
+	u, _ := url.Parse("http://example.com/a.mp3")
+	_, err := (&CastDevice{}).play(context.Background(), mediaRequest{url: u, retry: RetryPolicy{InitialBackoff: time.Hour}})
+	if !errors.Is(err, errNoAddress) {
+		t.Errorf("want %v, got %v", errNoAddress, err)
+	}
+}
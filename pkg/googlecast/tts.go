@@ -0,0 +1,158 @@
+package googlecast
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// TTSGoogleTranslate is a provider name of TranslateTTS
+const TTSGoogleTranslate = "google-translate"
+
+var errNoAudioURL = errors.New("audio has no URL which cast devices can fetch: generated audio requires a media server")
+
+// Audio is a synthesized speech. A provider sets either URL or Data.
+type Audio struct {
+	URL         *url.URL
+	Data        []byte
+	ContentType string
+}
+
+// MediaHost publishes audio data on an URL which cast devices can fetch
+type MediaHost interface {
+	Publish(data []byte, contentType string) (*url.URL, error)
+	Release(u *url.URL)
+}
+
+// TTSProvider converts a text to speech audio
+type TTSProvider interface {
+	Synthesize(ctx context.Context, text, lang, voice string) (*Audio, error)
+}
+
+// TranslateTTS provides text-to-speech sound url of Google Translate.
+// NOTE: it seems to be unofficial, and it does not support voices.
+type TranslateTTS struct{}
+
+// MaxTextLength returns a length which the endpoint accepts without truncation
+func (TranslateTTS) MaxTextLength() int { return 200 }
+
+// Synthesize returns an URL of speech audio
+func (TranslateTTS) Synthesize(_ context.Context, text, lang, _ string) (*Audio, error) {
+	base := "https://translate.google.com/translate_tts?client=tw-ob&ie=UTF-8&q=%s&tl=%s"
+	u, err := url.Parse(fmt.Sprintf(base, url.QueryEscape(text), url.QueryEscape(lang)))
+	if err != nil {
+		return nil, fmt.Errorf("build translate tts url: %w", err)
+	}
+	return &Audio{URL: u, ContentType: "audio/mp3"}, nil
+}
+
+const (
+	// TTSCommand is a provider name of CommandTTS with user-defined command
+	TTSCommand = "command"
+	// TTSEspeak is a provider name of CommandTTS with espeak-ng
+	TTSEspeak = "espeak-ng"
+	// TTSPico2Wave is a provider name of CommandTTS with pico2wave
+	TTSPico2Wave = "pico2wave"
+)
+
+// CommandTTS synthesizes speech on the host with a local synthesizer command.
+// It works without outbound internet access.
+type CommandTTS struct {
+	// Command is a command line template. {text}, {lang}, {voice} and {output} are replaced.
+	// If {output} is not contained, the command should write audio to stdout.
+	// {text} should follow "--", so that messages starting with "-" are not parsed as options.
+	Command []string
+	// ContentType of generated audio. Default is audio/wav
+	ContentType string
+	// Languages maps language codes to the codes which the command accepts
+	Languages map[string]string
+}
+
+// NewEspeakTTS returns CommandTTS using espeak-ng. A voice defaults to the language.
+func NewEspeakTTS() *CommandTTS {
+	return &CommandTTS{Command: []string{"espeak-ng", "-v", "{voice}", "-w", "{output}", "--", "{text}"}}
+}
+
+// NewPico2WaveTTS returns CommandTTS using pico2wave. It does not support voices.
+func NewPico2WaveTTS() *CommandTTS {
+	return &CommandTTS{
+		Command: []string{"pico2wave", "-l", "{lang}", "-w", "{output}", "--", "{text}"},
+		Languages: map[string]string{
+			"en": "en-US",
+			"de": "de-DE",
+			"es": "es-ES",
+			"fr": "fr-FR",
+			"it": "it-IT",
+		},
+	}
+}
+
+// Synthesize runs the command and returns generated audio data
+func (t *CommandTTS) Synthesize(ctx context.Context, text, lang, voice string) (*Audio, error) {
+	if len(t.Command) == 0 {
+		return nil, errors.New("tts command is empty")
+	}
+	contentType := t.ContentType
+	if contentType == "" {
+		contentType = "audio/wav"
+	}
+	if l, ok := t.Languages[lang]; ok {
+		lang = l
+	}
+	if voice == "" {
+		voice = lang
+	}
+
+	dir, err := ioutil.TempDir("", "notifyhome-tts")
+	if err != nil {
+		return nil, fmt.Errorf("create tts directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "speech"+audioExtension(contentType))
+
+	replacer := strings.NewReplacer("{text}", text, "{lang}", lang, "{voice}", voice, "{output}", output)
+	args := make([]string, len(t.Command))
+	toFile := false
+	for idx, arg := range t.Command {
+		if strings.Contains(arg, "{output}") {
+			toFile = true
+		}
+		args[idx] = replacer.Replace(arg)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("run %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	data := stdout.Bytes()
+	if toFile {
+		if data, err = ioutil.ReadFile(output); err != nil {
+			return nil, fmt.Errorf("read synthesized audio: %w", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%s generated no audio", args[0])
+	}
+	return &Audio{Data: data, ContentType: contentType}, nil
+}
+
+func audioExtension(contentType string) string {
+	switch contentType {
+	case "audio/mp3", "audio/mpeg":
+		return ".mp3"
+	case "audio/ogg":
+		return ".ogg"
+	}
+	return ".wav"
+}
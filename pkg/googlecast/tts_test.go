@@ -0,0 +1,69 @@
+package googlecast
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandTTS(t *testing.T) {
+	tests := map[string]struct {
+		command []string
+		want    string
+	}{
+		"output file": {
+			command: []string{"sh", "-c", `printf '%s/%s/%s' "$1" "$2" "$3" > "$0"`, "{output}", "{text}", "{lang}", "{voice}"},
+			want:    "hello/en-US/en-US",
+		},
+		"stdout": {
+			command: []string{"sh", "-c", `printf '%s' "$0"`, "{text}"},
+			want:    "hello",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			provider := &CommandTTS{Command: tt.command, Languages: map[string]string{"en": "en-US"}}
+			audio, err := provider.Synthesize(context.Background(), "hello", "en", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(audio.Data) != tt.want {
+				t.Errorf("want %q, got %q", tt.want, audio.Data)
+			}
+			if audio.ContentType != "audio/wav" {
+				t.Errorf("unexpected content type %s", audio.ContentType)
+			}
+		})
+	}
+}
+
+func TestCommandTTSOptionLikeText(t *testing.T) {
+	// writes arguments to the file following -w, instead of running the synthesizer
+	script := `for a; do [ "$prev" = -w ] && out=$a; prev=$a; done; printf '%s\n' "$@" > "$out"`
+	text := "-w/home/pi/.profile!"
+	tests := map[string]struct {
+		provider *CommandTTS
+		want     []string
+	}{
+		"espeak-ng": {provider: NewEspeakTTS(), want: []string{"-v", "en", "-w", "", "--", text}},
+		"pico2wave": {provider: NewPico2WaveTTS(), want: []string{"-l", "en-US", "-w", "", "--", text}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.provider.Command = append([]string{"sh", "-c", script, "tts"}, tt.provider.Command[1:]...)
+			audio, err := tt.provider.Synthesize(context.Background(), text, "en", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			args := strings.Split(strings.TrimSuffix(string(audio.Data), "\n"), "\n")
+			if len(args) == len(tt.want) {
+				// the output is a temporary file
+				args[3] = ""
+			}
+			if !reflect.DeepEqual(args, tt.want) {
+				t.Errorf("want %q, got %q", tt.want, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package googlecast
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/barnybug/go-cast/controllers"
+	castnet "github.com/barnybug/go-cast/net"
+)
+
+// restoreTimeout is a timeout to restore a state of a device after an announcement, which runs even if the context is done
+const restoreTimeout = 10 * time.Second
+
+// GetVolume returns a volume level of the device between 0 and 1
+func (g *CastDevice) GetVolume(ctx context.Context) (float64, error) {
+	rec, err := g.connected(ctx)
+	if err != nil {
+		return 0, err
+	}
+	status, err := receiverStatus(ctx, rec)
+	if err != nil {
+		return 0, err
+	}
+	if volume := status.Volume; volume == nil || volume.Level == nil {
+		return 0, fmt.Errorf("%s does not report volume", g.Name())
+	}
+	return *status.Volume.Level, nil
+}
+
+// SetVolume sets a volume level of the device between 0 and 1
+func (g *CastDevice) SetVolume(ctx context.Context, level float64) error {
+	if level < 0 || level > 1 {
+		return fmt.Errorf("volume must be between 0 and 1: %v", level)
+	}
+	rec, err := g.connected(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = rec.request(ctx, "SET_VOLUME", func(headers castnet.PayloadHeaders) interface{} {
+		return &controllers.ReceiverStatus{PayloadHeaders: headers, Volume: &controllers.Volume{Level: &level}}
+	})
+	return err
+}
+
+// overrideVolume sets the volume level for an announcement, and returns a function to restore the previous level.
+// Failures are only logged, since the announcement should be played anyway.
+func (g *CastDevice) overrideVolume(ctx context.Context, level float64) func() {
+	previous, err := g.GetVolume(ctx)
+	if err != nil {
+		log.Printf("[WARN] get volume of %s: %s", g.Name(), err)
+		return func() {}
+	}
+	if previous == level {
+		return func() {}
+	}
+	if err := g.SetVolume(ctx, level); err != nil {
+		log.Printf("[WARN] set volume of %s: %s", g.Name(), err)
+		return func() {}
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+		defer cancel()
+		if err := g.SetVolume(ctx, previous); err != nil {
+			log.Printf("[WARN] restore volume of %s: %s", g.Name(), err)
+		}
+	}
+}
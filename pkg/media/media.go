@@ -0,0 +1,193 @@
+package media
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Path is an URL path prefix of hosted media files
+	Path = "/media/"
+
+	// DefaultTTL is a lifetime of hosted media files
+	DefaultTTL      = 10 * time.Minute
+	cleanupInterval = time.Minute
+)
+
+type (
+	// Server hosts audio files on short-lived tokenized URLs which cast devices can fetch
+	Server struct {
+		baseURL *url.URL
+		ttl     time.Duration
+		dir     string
+
+		mu    sync.Mutex
+		files map[string]*file
+	}
+
+	file struct {
+		path        string
+		contentType string
+		expires     time.Time
+	}
+)
+
+// NewServer returns a media server. baseURL is an advertised URL of the server which includes Path.
+func NewServer(baseURL *url.URL, ttl time.Duration) (*Server, error) {
+	dir, err := ioutil.TempDir("", "notifyhome-media")
+	if err != nil {
+		return nil, fmt.Errorf("create media directory: %w", err)
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Server{baseURL: baseURL, ttl: ttl, dir: dir, files: map[string]*file{}}, nil
+}
+
+// BaseURL returns an advertised URL of the host
+func BaseURL(host string, port int) (*url.URL, error) {
+	if host == "" {
+		ip, err := LocalIP()
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+	return url.Parse(fmt.Sprintf("http://%s%s", net.JoinHostPort(host, fmt.Sprint(port)), Path))
+}
+
+// LocalIP returns a LAN address of the host.
+// It looks up a route to the mDNS multicast address, so no packets are sent.
+func LocalIP() (net.IP, error) {
+	conn, err := net.Dial("udp4", "224.0.0.251:5353")
+	if err != nil {
+		return nil, fmt.Errorf("detect LAN address: %w", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Publish saves data and returns a tokenized URL of it
+func (s *Server) Publish(data []byte, contentType string) (*url.URL, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	p := filepath.Join(s.dir, token)
+	if err := ioutil.WriteFile(p, data, 0600); err != nil {
+		return nil, fmt.Errorf("save media: %w", err)
+	}
+	s.mu.Lock()
+	s.files[token] = &file{path: p, contentType: contentType, expires: time.Now().Add(s.ttl)}
+	s.mu.Unlock()
+	return s.baseURL.Parse(token)
+}
+
+// Release removes a published file
+func (s *Server) Release(u *url.URL) {
+	if u == nil {
+		return
+	}
+	s.remove(path.Base(u.Path))
+}
+
+// ServeHTTP serves a published file of the token
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "Invalid methods", http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimPrefix(req.URL.Path, Path)
+	s.mu.Lock()
+	f, ok := s.files[token]
+	s.mu.Unlock()
+	if !ok || f.expires.Before(time.Now()) {
+		http.NotFound(w, req)
+		return
+	}
+	content, err := os.Open(f.path)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer content.Close()
+	if f.contentType != "" {
+		w.Header().Set("Content-Type", f.contentType)
+	}
+	http.ServeContent(w, req, token, time.Time{}, content)
+}
+
+// Run removes expired files regularly, and removes all files after ctx is done
+func (s *Server) Run(ctx context.Context) {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.removeExpired()
+		case <-ctx.Done():
+			s.Close()
+			return
+		}
+	}
+}
+
+// Close removes all files
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.files = map[string]*file{}
+	s.mu.Unlock()
+	if err := os.RemoveAll(s.dir); err != nil {
+		log.Printf("remove media directory: %+v\n", err)
+	}
+}
+
+func (s *Server) removeExpired() {
+	now := time.Now()
+	s.mu.Lock()
+	expired := []string{}
+	for token, f := range s.files {
+		if f.expires.Before(now) {
+			expired = append(expired, token)
+		}
+	}
+	s.mu.Unlock()
+	for _, token := range expired {
+		s.remove(token)
+	}
+}
+
+func (s *Server) remove(token string) {
+	s.mu.Lock()
+	f, ok := s.files[token]
+	delete(s.files, token)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("remove media %s: %+v\n", token, err)
+	}
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate media token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
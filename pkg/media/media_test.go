@@ -0,0 +1,46 @@
+package media
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	baseURL, _ := url.Parse("http://192.168.0.2:8000" + Path)
+	s, err := NewServer(baseURL, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	u, err := s.Publish([]byte("RIFF"), "audio/wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != baseURL.Host || len(u.Path) <= len(Path) {
+		t.Fatalf("unexpected media url: %s", u)
+	}
+
+	get := func() *http.Response {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, u.Path, nil))
+		return w.Result()
+	}
+	resp := get()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "RIFF" {
+		t.Errorf("unexpected response: %d %q", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "audio/wav" {
+		t.Errorf("unexpected content type: %s", ct)
+	}
+
+	s.Release(u)
+	if resp := get(); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("released media is served: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,104 @@
+// Package multierr aggregates errors which occurred independently on sources such as devices or calendar accounts.
+package multierr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// SourceError is an error which occurred on a source
+	SourceError struct {
+		// Source identifies where the error occurred, such as a device name or a calendar account
+		Source string
+		Err    error
+	}
+
+	// Errors is a list of errors. errors.Is and errors.As match any of them.
+	Errors []error
+)
+
+// Wrap returns an error of the source, or nil if err is nil
+func Wrap(source string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &SourceError{Source: source, Err: err}
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
+
+// Append appends errs to err, and flattens Errors. nil errors are ignored, and nil is returned if no error remains.
+func Append(err error, errs ...error) error {
+	merged := Errors{}
+	for _, e := range append([]error{err}, errs...) {
+		if e != nil {
+			merged = append(merged, Split(e)...)
+		}
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	return merged
+}
+
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, len(e))
+	for idx, err := range e {
+		msgs[idx] = err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of errors matches the target
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error which matches the target
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources returns sources of errors. Errors without source are skipped.
+func (e Errors) Sources() []string {
+	sources := []string{}
+	for _, err := range e {
+		var sourceErr *SourceError
+		if errors.As(err, &sourceErr) {
+			sources = append(sources, sourceErr.Source)
+		}
+	}
+	return sources
+}
+
+// Split returns errors in err. It returns a single error if err is not Errors, and nil if err is nil.
+func Split(err error) Errors {
+	if err == nil {
+		return nil
+	}
+	if list, ok := err.(Errors); ok {
+		return list
+	}
+	return Errors{err}
+}
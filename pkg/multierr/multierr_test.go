@@ -0,0 +1,51 @@
+package multierr
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestAppend(t *testing.T) {
+	errA := errors.New("a")
+	errB := Wrap("Kitchen", errors.New("b"))
+	tests := map[string]struct {
+		err  error
+		errs []error
+		want error
+	}{
+		"nil":        {want: nil},
+		"nil errs":   {errs: []error{nil, nil}, want: nil},
+		"single":     {errs: []error{errA}, want: Errors{errA}},
+		"flatten":    {err: Errors{errA}, errs: []error{Errors{errB}, nil}, want: Errors{errA, errB}},
+		"not listed": {err: errA, errs: []error{errB}, want: Errors{errA, errB}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := Append(tt.err, tt.errs...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	err := Append(Wrap("Kitchen", context.DeadlineExceeded), Wrap("account 1", errors.New("unauthorized")), errors.New("no source"))
+	if want := "3 errors occurred: Kitchen: context deadline exceeded; account 1: unauthorized; no source"; err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("errors.Is does not match a wrapped error")
+	}
+	var sourceErr *SourceError
+	if !errors.As(err, &sourceErr) || sourceErr.Source != "Kitchen" {
+		t.Errorf("errors.As does not find the first source error: %v", sourceErr)
+	}
+	if sources := Split(err).Sources(); !reflect.DeepEqual(sources, []string{"Kitchen", "account 1"}) {
+		t.Errorf("unexpected sources: %v", sources)
+	}
+	if single := Append(Wrap("Kitchen", errors.New("b"))); single.Error() != "Kitchen: b" {
+		t.Errorf("unexpected single error: %s", single)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope is an operation which a key is allowed
+type Scope string
+
+const (
+	// ScopeNotify allows notifying messages and playing audio
+	ScopeNotify Scope = "notify"
+	// ScopeQuiet allows silencing notifications
+	ScopeQuiet Scope = "quiet"
+	// ScopeAdmin allows all operations, including listing devices and queues
+	ScopeAdmin Scope = "admin"
+
+	// SignatureHeader is a header of an HMAC-SHA256 signature formatted as "sha256=<hex>"
+	SignatureHeader = "X-Signature"
+	// TimestampHeader is a header of a signed time in unix seconds
+	TimestampHeader = "X-Signature-Timestamp"
+
+	// maxSignatureAge is a maximum difference between a signed time and the server time.
+	// Signatures are remembered for the duration, so that each signature is accepted only once.
+	maxSignatureAge = 5 * time.Minute
+)
+
+var (
+	errUnauthorized = errors.New("valid API key or signature is required")
+	errForbidden    = errors.New("API key is not allowed the operation")
+	errReplayed     = errors.New("signature was already used")
+)
+
+// Key is a named API key of a client.
+// Requests are authenticated by the token as "Authorization: Bearer <token>" or "X-API-Key: <token>",
+// or signed by the secret as X-Signature over "<X-Signature-Timestamp>.<METHOD>.<path>[?<query>].<body>".
+type Key struct {
+	Name   string
+	Token  string
+	Secret string
+	Scopes []Scope
+}
+
+// ValidateKeys returns an error if keys are incomplete, or tokens and names are duplicated
+func ValidateKeys(keys []Key) error {
+	names := map[string]bool{}
+	tokens := map[string]bool{}
+	for _, key := range keys {
+		if key.Name == "" {
+			return errors.New("API key must have a name")
+		}
+		if names[key.Name] {
+			return fmt.Errorf("duplicated API key name: %s", key.Name)
+		}
+		names[key.Name] = true
+		if key.Token == "" && key.Secret == "" {
+			return fmt.Errorf("API key %s must have a token or a secret", key.Name)
+		}
+		if key.Token != "" {
+			if tokens[key.Token] {
+				return fmt.Errorf("API key %s has a duplicated token", key.Name)
+			}
+			tokens[key.Token] = true
+		}
+		if len(key.Scopes) == 0 {
+			return fmt.Errorf("API key %s must have scopes", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			switch scope {
+			case ScopeNotify, ScopeQuiet, ScopeAdmin:
+			default:
+				return fmt.Errorf("unknown scope of API key %s: %s", key.Name, scope)
+			}
+		}
+	}
+	return nil
+}
+
+func (k Key) allows(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticator authorizes requests by keys. It allows all requests if there is no key.
+type authenticator struct {
+	keys []Key
+	now  func() time.Time
+
+	mu sync.Mutex
+	// used is signatures accepted within maxSignatureAge, and their signed times
+	used map[string]time.Time
+}
+
+// keyContextKey is a context key of an authenticated key
+type keyContextKey struct{}
+
+func newAuthenticator(keys []Key) *authenticator {
+	if len(keys) == 0 {
+		log.Print("[WARN] server does not authenticate requests: no API key is defined")
+	}
+	return &authenticator{keys: keys, now: time.Now, used: map[string]time.Time{}}
+}
+
+// require wraps the handler, which is only called for keys with the scope
+func (a *authenticator) require(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if len(a.keys) == 0 {
+			next(w, req)
+			return
+		}
+		key, err := a.authenticate(w, req)
+		if err != nil {
+			log.Printf("[WARN] reject %s %s from %s: %s", req.Method, req.URL.Path, req.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="notify"`)
+			writeJSON(w, http.StatusUnauthorized, errorResponse{Error: errUnauthorized.Error()})
+			return
+		}
+		if !key.allows(scope) {
+			log.Printf("[WARN] reject %s %s by %s: no %s scope", req.Method, req.URL.Path, key.Name, scope)
+			writeJSON(w, http.StatusForbidden, errorResponse{Error: errForbidden.Error()})
+			return
+		}
+		log.Printf("[INFO] %s %s by %s", req.Method, req.URL.Path, key.Name)
+		next(w, req.WithContext(context.WithValue(req.Context(), keyContextKey{}, key)))
+	}
+}
+
+// authenticate returns a key of a token or a signature of the request
+func (a *authenticator) authenticate(w http.ResponseWriter, req *http.Request) (Key, error) {
+	if token := requestToken(req); token != "" {
+		for _, key := range a.keys {
+			if key.Token != "" && subtle.ConstantTimeCompare([]byte(key.Token), []byte(token)) == 1 {
+				return key, nil
+			}
+		}
+		return Key{}, errors.New("unknown token")
+	}
+	if req.Header.Get(SignatureHeader) != "" {
+		return a.verifySignature(w, req)
+	}
+	return Key{}, errors.New("no credential")
+}
+
+func requestToken(req *http.Request) string {
+	if token := req.Header.Get("X-API-Key"); token != "" {
+		return token
+	}
+	auth := req.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// verifySignature returns a key which secret signed the timestamp, the method, the request URI and the body.
+// The body is read, and replaced to be read again by the handler.
+func (a *authenticator) verifySignature(w http.ResponseWriter, req *http.Request) (Key, error) {
+	signature := strings.TrimPrefix(req.Header.Get(SignatureHeader), "sha256=")
+	mac, err := hex.DecodeString(signature)
+	if err != nil {
+		return Key{}, errors.New("signature must be sha256=<hex>")
+	}
+	timestamp := req.Header.Get(TimestampHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid %s: %q", TimestampHeader, timestamp)
+	}
+	signedAt := time.Unix(sec, 0)
+	if age := a.now().Sub(signedAt); age > maxSignatureAge || age < -maxSignatureAge {
+		return Key{}, fmt.Errorf("signature is expired: signed at %s", signedAt.Format(time.RFC3339))
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxUploadSize))
+	if err != nil {
+		return Key{}, fmt.Errorf("read body: %w", err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	for _, key := range a.keys {
+		if key.Secret != "" && hmac.Equal(mac, sign(key.Secret, timestamp, req.Method, req.URL.RequestURI(), body)) {
+			if !a.remember(hex.EncodeToString(mac), signedAt) {
+				return Key{}, errReplayed
+			}
+			return key, nil
+		}
+	}
+	return Key{}, errors.New("invalid signature")
+}
+
+// remember records a verified signature. It returns false if the signature was already recorded.
+// Signatures are removed after they expire, since expired signatures are rejected anyway.
+func (a *authenticator) remember(signature string, signedAt time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	for s, at := range a.used {
+		if now.Sub(at) > maxSignatureAge {
+			delete(a.used, s)
+		}
+	}
+	if _, ok := a.used[signature]; ok {
+		return false
+	}
+	a.used[signature] = signedAt
+	return true
+}
+
+// sign returns an HMAC-SHA256 of the timestamp, the method, the request URI and the body
+func sign(secret, timestamp, method, uri string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp + "." + method + "." + uri + "."))
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator(t *testing.T) {
+	now := time.Date(2021, 1, 20, 13, 0, 0, 0, time.UTC)
+	keys := []Key{
+		{Name: "home-assistant", Token: "notify-token", Scopes: []Scope{ScopeNotify}},
+		{Name: "phone", Token: "quiet-token", Scopes: []Scope{ScopeQuiet}},
+		{Name: "owner", Token: "admin-token", Scopes: []Scope{ScopeAdmin}},
+		{Name: "webhook", Secret: "webhook-secret", Scopes: []Scope{ScopeNotify}},
+	}
+	signed := func(secret string, at time.Time, uri, body string) http.Header {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		return http.Header{
+			SignatureHeader: {"sha256=" + hex.EncodeToString(sign(secret, timestamp, http.MethodPost, uri, []byte(body)))},
+			TimestampHeader: {timestamp},
+		}
+	}
+	tests := map[string]struct {
+		keys   []Key
+		scope  Scope
+		header http.Header
+		want   int
+	}{
+		"no keys":          {scope: ScopeAdmin, want: http.StatusOK},
+		"no credential":    {keys: keys, scope: ScopeNotify, want: http.StatusUnauthorized},
+		"bearer":           {keys: keys, scope: ScopeNotify, header: http.Header{"Authorization": {"Bearer notify-token"}}, want: http.StatusOK},
+		"api key header":   {keys: keys, scope: ScopeNotify, header: http.Header{"X-Api-Key": {"notify-token"}}, want: http.StatusOK},
+		"unknown token":    {keys: keys, scope: ScopeNotify, header: http.Header{"Authorization": {"Bearer guess"}}, want: http.StatusUnauthorized},
+		"out of scope":     {keys: keys, scope: ScopeNotify, header: http.Header{"Authorization": {"Bearer quiet-token"}}, want: http.StatusForbidden},
+		"quiet scope":      {keys: keys, scope: ScopeQuiet, header: http.Header{"Authorization": {"Bearer quiet-token"}}, want: http.StatusOK},
+		"admin":            {keys: keys, scope: ScopeQuiet, header: http.Header{"Authorization": {"Bearer admin-token"}}, want: http.StatusOK},
+		"admin only":       {keys: keys, scope: ScopeAdmin, header: http.Header{"Authorization": {"Bearer notify-token"}}, want: http.StatusForbidden},
+		"signature":        {keys: keys, scope: ScopeNotify, header: signed("webhook-secret", now, "/notify?priority=high", "Dinner is ready"), want: http.StatusOK},
+		"wrong secret":     {keys: keys, scope: ScopeNotify, header: signed("guess", now, "/notify?priority=high", "Dinner is ready"), want: http.StatusUnauthorized},
+		"tampered body":    {keys: keys, scope: ScopeNotify, header: signed("webhook-secret", now, "/notify?priority=high", "Dinner is not ready"), want: http.StatusUnauthorized},
+		"expired":          {keys: keys, scope: ScopeNotify, header: signed("webhook-secret", now.Add(-10*time.Minute), "/notify?priority=high", "Dinner is ready"), want: http.StatusUnauthorized},
+		"tampered query":   {keys: keys, scope: ScopeNotify, header: signed("webhook-secret", now, "/notify?priority=low", "Dinner is ready"), want: http.StatusUnauthorized},
+		"changed path":     {keys: keys, scope: ScopeNotify, header: signed("webhook-secret", now, "/play?priority=high", "Dinner is ready"), want: http.StatusUnauthorized},
+		"signature scope":  {keys: keys, scope: ScopeQuiet, header: signed("webhook-secret", now, "/notify?priority=high", "Dinner is ready"), want: http.StatusForbidden},
+		"secret not token": {keys: keys, scope: ScopeNotify, header: http.Header{"Authorization": {"Bearer webhook-secret"}}, want: http.StatusUnauthorized},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			auth := newAuthenticator(tt.keys)
+			auth.now = func() time.Time { return now }
+			handler := auth.require(tt.scope, func(w http.ResponseWriter, req *http.Request) {
+				// handlers read the body after signatures are verified
+				b, _ := ioutil.ReadAll(req.Body)
+				if string(b) != "Dinner is ready" {
+					t.Errorf("unexpected body: %q", b)
+				}
+			})
+			req := httptest.NewRequest(http.MethodPost, "/notify?priority=high", strings.NewReader("Dinner is ready"))
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if w.Code != tt.want {
+				t.Errorf("want status %d, got %d: %s", tt.want, w.Code, w.Body)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorReplay(t *testing.T) {
+	now := time.Date(2021, 1, 20, 13, 0, 0, 0, time.UTC)
+	auth := newAuthenticator([]Key{{Name: "webhook", Secret: "webhook-secret", Scopes: []Scope{ScopeNotify}}})
+	auth.now = func() time.Time { return now }
+	handler := auth.require(ScopeNotify, func(w http.ResponseWriter, req *http.Request) {})
+	send := func(at time.Time) int {
+		timestamp := strconv.FormatInt(at.Unix(), 10)
+		req := httptest.NewRequest(http.MethodPost, "/notify", strings.NewReader("Dinner is ready"))
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(sign("webhook-secret", timestamp, http.MethodPost, "/notify", []byte("Dinner is ready"))))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	signedAt := now
+	if code := send(signedAt); code != http.StatusOK {
+		t.Fatalf("want status %d, got %d", http.StatusOK, code)
+	}
+	now = now.Add(time.Minute)
+	if code := send(signedAt); code != http.StatusUnauthorized {
+		t.Errorf("replayed signature: want status %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := send(now); code != http.StatusOK {
+		t.Errorf("new signature: want status %d, got %d", http.StatusOK, code)
+	}
+	now = now.Add(maxSignatureAge + time.Minute)
+	if len(auth.used) != 2 || send(now) != http.StatusOK || len(auth.used) != 1 {
+		t.Errorf("expired signatures are not removed: %d", len(auth.used))
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+	tests := map[string]struct {
+		keys    []Key
+		wantErr bool
+	}{
+		"empty":           {},
+		"valid":           {keys: []Key{{Name: "a", Token: "t", Scopes: []Scope{ScopeNotify}}, {Name: "b", Secret: "s", Scopes: []Scope{ScopeAdmin}}}},
+		"no name":         {keys: []Key{{Token: "t", Scopes: []Scope{ScopeNotify}}}, wantErr: true},
+		"no credential":   {keys: []Key{{Name: "a", Scopes: []Scope{ScopeNotify}}}, wantErr: true},
+		"no scope":        {keys: []Key{{Name: "a", Token: "t"}}, wantErr: true},
+		"unknown scope":   {keys: []Key{{Name: "a", Token: "t", Scopes: []Scope{"root"}}}, wantErr: true},
+		"duplicate name":  {keys: []Key{{Name: "a", Token: "t", Scopes: []Scope{ScopeNotify}}, {Name: "a", Token: "u", Scopes: []Scope{ScopeNotify}}}, wantErr: true},
+		"duplicate token": {keys: []Key{{Name: "a", Token: "t", Scopes: []Scope{ScopeNotify}}, {Name: "b", Token: "t", Scopes: []Scope{ScopeNotify}}}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateKeys(tt.keys); (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
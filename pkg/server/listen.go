@@ -0,0 +1,210 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// unixPrefix is a prefix of listen addresses of Unix domain sockets
+const unixPrefix = "unix:"
+
+// TLSConfig is certificate files of the server. Empty files serve plain HTTP.
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is CA certificates which verify client certificates. Empty does not require client certificates.
+	ClientCAFile string
+}
+
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != ""
+}
+
+func (c TLSConfig) validate() error {
+	if c.enabled() && (c.CertFile == "" || c.KeyFile == "") {
+		return errors.New("both TLS certificate and key files are required")
+	}
+	if c.ClientCAFile != "" && !c.enabled() {
+		return errors.New("client certificate verification requires TLS certificate and key files")
+	}
+	return nil
+}
+
+// config returns a TLS config which reloads the certificate when the files are updated
+func (c TLSConfig) config() (*tls.Config, error) {
+	certs, err := newCertReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.getCertificate,
+	}
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in client CA: %s", c.ClientCAFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
+
+// certReloader loads a certificate again when the certificate or the key file is modified,
+// so that renewed certificates are served without restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) getCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if modTime, err := r.lastModified(); err == nil && modTime.After(r.modTime) {
+		if err := r.reloadLocked(); err != nil {
+			// keeps the current certificate while files are being replaced
+			log.Printf("[WARN] reload TLS certificate: %s", err)
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *certReloader) reloadLocked() error {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	if r.cert != nil {
+		log.Printf("[INFO] reloaded TLS certificate: %s", r.certFile)
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+// lastModified returns the latest modification time of the certificate and the key files
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// address returns a network and an address to listen.
+// A TCP address without a port listens on the port.
+func (c Config) address() (network, address string) {
+	if strings.HasPrefix(c.Listen, unixPrefix) {
+		return "unix", strings.TrimPrefix(c.Listen, unixPrefix)
+	}
+	if c.Listen == "" {
+		return "tcp", fmt.Sprintf(":%d", c.Port)
+	}
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return "tcp", net.JoinHostPort(strings.Trim(c.Listen, "[]"), strconv.Itoa(c.Port))
+	}
+	return "tcp", c.Listen
+}
+
+// listen listens on the address. A stale Unix domain socket of a previous process is removed,
+// but a socket which another process listens on is kept.
+func (c Config) listen() (net.Listener, error) {
+	network, address := c.address()
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil {
+			return nil, fmt.Errorf("listen %s: %w", address, err)
+		}
+	}
+	ln, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", address, err)
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket if nobody listens on it
+func removeStaleSocket(address string) error {
+	info, err := os.Stat(address)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", address, time.Second)
+	if err == nil {
+		conn.Close()
+		return errors.New("address already in use")
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("check socket: %w", err)
+	}
+	if err := os.Remove(address); err != nil {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	return nil
+}
+
+// MediaPort returns a port on which cast devices can fetch media files from the server.
+// Devices only fetch plain HTTP on LAN addresses, so it returns false for TLS, Unix domain sockets or loopback addresses.
+func (c Config) MediaPort() (int, bool) {
+	if c.TLS.enabled() {
+		return 0, false
+	}
+	network, address := c.address()
+	if network != "tcp" {
+		return 0, false
+	}
+	host, p, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, false
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return 0, false
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return 0, false
+	}
+	return port, true
+}
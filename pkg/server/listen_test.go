@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigAddress(t *testing.T) {
+	tests := map[string]struct {
+		conf        Config
+		wantNetwork string
+		wantAddress string
+		wantPort    int
+		wantMedia   bool
+	}{
+		"default":      {conf: Config{Port: 8000}, wantNetwork: "tcp", wantAddress: ":8000", wantPort: 8000, wantMedia: true},
+		"lan address":  {conf: Config{Port: 8000, Listen: "192.168.1.2:9000"}, wantNetwork: "tcp", wantAddress: "192.168.1.2:9000", wantPort: 9000, wantMedia: true},
+		"host only":    {conf: Config{Port: 8000, Listen: "127.0.0.1"}, wantNetwork: "tcp", wantAddress: "127.0.0.1:8000"},
+		"localhost":    {conf: Config{Port: 8000, Listen: "localhost:8000"}, wantNetwork: "tcp", wantAddress: "localhost:8000"},
+		"ipv6":         {conf: Config{Port: 8000, Listen: "[::1]"}, wantNetwork: "tcp", wantAddress: "[::1]:8000"},
+		"unix socket":  {conf: Config{Port: 8000, Listen: "unix:/run/notify.sock"}, wantNetwork: "unix", wantAddress: "/run/notify.sock"},
+		"tls":          {conf: Config{Port: 8443, TLS: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}}, wantNetwork: "tcp", wantAddress: ":8443"},
+		"wildcard ip6": {conf: Config{Port: 8000, Listen: "[::]:8000"}, wantNetwork: "tcp", wantAddress: "[::]:8000", wantPort: 8000, wantMedia: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			network, address := tt.conf.address()
+			if network != tt.wantNetwork || address != tt.wantAddress {
+				t.Errorf("want %s %s, got %s %s", tt.wantNetwork, tt.wantAddress, network, address)
+			}
+			port, ok := tt.conf.MediaPort()
+			if port != tt.wantPort || ok != tt.wantMedia {
+				t.Errorf("want media port %d %v, got %d %v", tt.wantPort, tt.wantMedia, port, ok)
+			}
+		})
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifyhome-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := Config{Listen: unixPrefix + filepath.Join(dir, "notify.sock")}
+
+	// a socket of a crashed process is left
+	stale, err := net.Listen("unix", filepath.Join(dir, "notify.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := conf.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeResponse(w, []byte("ok"))
+	}))
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", filepath.Join(dir, "notify.sock"))
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+}
+
+func TestListenLiveUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifyhome-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := Config{Listen: unixPrefix + filepath.Join(dir, "notify.sock")}
+
+	// another process listens on the socket
+	live, err := net.Listen("unix", filepath.Join(dir, "notify.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	go func() {
+		for {
+			conn, err := live.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	if ln, err := conf.listen(); err == nil {
+		ln.Close()
+		t.Fatal("listen on a live socket must fail")
+	}
+	conn, err := net.Dial("unix", filepath.Join(dir, "notify.sock"))
+	if err != nil {
+		t.Fatalf("live socket is removed: %s", err)
+	}
+	conn.Close()
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	tests := map[string]struct {
+		conf    TLSConfig
+		wantErr bool
+	}{
+		"plain":          {},
+		"tls":            {conf: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}},
+		"mutual tls":     {conf: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem"}},
+		"no key":         {conf: TLSConfig{CertFile: "cert.pem"}, wantErr: true},
+		"no cert":        {conf: TLSConfig{KeyFile: "key.pem"}, wantErr: true},
+		"client ca only": {conf: TLSConfig{ClientCAFile: "ca.pem"}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.conf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// testCert is a certificate signed by parent, or a self-signed CA if parent is nil
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, certFile, keyFile string, modTime time.Time) {
+	t.Helper()
+	for name, data := range map[string][]byte{certFile: c.certPEM, keyFile: c.keyPEM} {
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notifyhome-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	ca := newTestCert(t, "ca", nil)
+	if err := ioutil.WriteFile(caFile, ca.certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	first := newTestCert(t, "first", ca)
+	first.write(t, certFile, keyFile, time.Now().Add(-time.Hour))
+
+	conf, err := TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile}.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		writeResponse(w, []byte("ok"))
+	}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCerts ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: clientCerts},
+		}}
+		resp, err := client.Get("https://" + ln.Addr().String())
+		if err == nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+	client := newTestCert(t, "client", ca).tlsCertificate(t)
+
+	resp, err := get(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.TLS.PeerCertificates[0].Subject.CommonName; got != "first" {
+		t.Errorf("want first certificate, got %s", got)
+	}
+	if _, err := get(); err == nil {
+		t.Error("request without a client certificate is accepted")
+	}
+	if _, err := get(newTestCert(t, "stranger", newTestCert(t, "other ca", nil)).tlsCertificate(t)); err == nil {
+		t.Error("client certificate of an unknown CA is accepted")
+	}
+
+	// renewed certificate is served without restart
+	newTestCert(t, "second", ca).write(t, certFile, keyFile, time.Now())
+	resp, err = get(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.TLS.PeerCertificates[0].Subject.CommonName; got != "second" {
+		t.Errorf("want second certificate, got %s", got)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
+)
+
+// maxNotifyRequestSize is a maximum size of JSON notification requests
+const maxNotifyRequestSize = 64 << 10
+
+// localePattern accepts language tags such as "en" or "en-US"
+var localePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+type (
+	// notifyRequest is a JSON body of POST /notify. Empty fields use options of the server.
+	notifyRequest struct {
+		Message string `json:"message"`
+		Locale  string `json:"locale"`
+		// Devices, Group and CastGroup replace default targets of the server
+		Devices   []string `json:"devices"`
+		Group     string   `json:"group"`
+		CastGroup string   `json:"cast_group"`
+		// Volume is a volume percentage between 1 and 100 during the announcement
+		Volume   *int   `json:"volume"`
+		Priority string `json:"priority"`
+		// Chime is "builtin", "none" or an http(s) URL. Local files are not accepted from requests.
+		Chime string `json:"chime"`
+		Voice string `json:"voice"`
+		Wait  *bool  `json:"wait"`
+	}
+
+	// errorResponse is a JSON body of failed requests
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+// isJSON reports whether the request body is JSON
+func isJSON(req *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// decodeNotifyRequest decodes and validates a JSON notification request
+func decodeNotifyRequest(r io.Reader) (notifyRequest, error) {
+	var body notifyRequest
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		return body, fmt.Errorf("invalid JSON request: %w", err)
+	}
+	return body, body.validate()
+}
+
+func (r notifyRequest) validate() error {
+	if strings.TrimSpace(r.Message) == "" {
+		return errors.New("message is required")
+	}
+	if r.Locale != "" && !localePattern.MatchString(r.Locale) {
+		return fmt.Errorf("invalid locale: %q", r.Locale)
+	}
+	for _, device := range r.Devices {
+		if strings.TrimSpace(device) == "" {
+			return errors.New("devices must not contain empty names")
+		}
+	}
+	if r.Volume != nil && (*r.Volume < 1 || *r.Volume > 100) {
+		return errors.New("volume must be between 1 and 100")
+	}
+	switch r.Chime {
+	case "", googlecast.ChimeBuiltin, googlecast.ChimeNone:
+	default:
+		// LoadChime reads a file unless the name starts with the scheme
+		isURL := strings.HasPrefix(r.Chime, "http://") || strings.HasPrefix(r.Chime, "https://")
+		if _, err := url.Parse(r.Chime); err != nil || !isURL {
+			return fmt.Errorf("chime must be %q, %q or an http(s) URL", googlecast.ChimeBuiltin, googlecast.ChimeNone)
+		}
+	}
+	return nil
+}
+
+// options applies the request to options of the server
+func (r notifyRequest) options(opts googlecast.Options) (googlecast.Options, error) {
+	if len(r.Devices) > 0 || r.Group != "" || r.CastGroup != "" {
+		opts.FriendlyName = ""
+		opts.DeviceNames = r.Devices
+		opts.Group = r.Group
+		opts.CastGroup = r.CastGroup
+		if _, ok := opts.Groups[r.Group]; r.Group != "" && !ok {
+			return opts, fmt.Errorf("unknown device group: %s", r.Group)
+		}
+	}
+	if r.Locale != "" {
+		opts.Locale = r.Locale
+	}
+	if r.Voice != "" {
+		opts.Voice = r.Voice
+	}
+	if r.Priority != "" {
+		opts.Priority = r.Priority
+	}
+	if r.Volume != nil {
+		opts.Volume = float64(*r.Volume) / 100
+	}
+	if r.Wait != nil {
+		opts.Wait = *r.Wait
+	}
+	if r.Chime != "" {
+		chime, err := googlecast.LoadChime(r.Chime)
+		if err != nil {
+			return opts, err
+		}
+		// the chime of the request is played for any priority
+		opts.Chimes = nil
+		if chime != nil {
+			opts.Chimes = map[string]*googlecast.Audio{"": chime}
+		}
+	}
+	return opts, nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
+)
+
+func TestDecodeNotifyRequest(t *testing.T) {
+	tests := map[string]struct {
+		body    string
+		wantErr bool
+	}{
+		"message":       {body: `{"message": "Dinner is ready"}`},
+		"all fields":    {body: `{"message": "Dinner", "locale": "en-US", "devices": ["Kitchen"], "group": "downstairs", "cast_group": "Whole House", "volume": 60, "priority": "high", "chime": "builtin", "voice": "female", "wait": true}`},
+		"chime url":     {body: `{"message": "Dinner", "chime": "https://example.com/chime.mp3"}`},
+		"no message":    {body: `{"locale": "en"}`, wantErr: true},
+		"blank message": {body: `{"message": "  "}`, wantErr: true},
+		"bad locale":    {body: `{"message": "Dinner", "locale": "en_US!"}`, wantErr: true},
+		"empty device":  {body: `{"message": "Dinner", "devices": [""]}`, wantErr: true},
+		"loud volume":   {body: `{"message": "Dinner", "volume": 101}`, wantErr: true},
+		"zero volume":   {body: `{"message": "Dinner", "volume": 0}`, wantErr: true},
+		"chime file":    {body: `{"message": "Dinner", "chime": "/etc/passwd"}`, wantErr: true},
+		"unknown field": {body: `{"message": "Dinner", "devcie": "Kitchen"}`, wantErr: true},
+		"not json":      {body: `Dinner is ready`, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeNotifyRequest(strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNotifyRequestOptions(t *testing.T) {
+	volume, wait := 60, true
+	base := googlecast.Options{
+		FriendlyName: "Living Room",
+		Groups:       map[string][]string{"downstairs": {"Living Room", "Kitchen"}},
+		Locale:       "en",
+		Chimes:       map[string]*googlecast.Audio{"high": {ContentType: "audio/mpeg"}},
+	}
+
+	opts, err := notifyRequest{Message: "Dinner"}.options(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opts, base) {
+		t.Errorf("empty fields changed options: %+v", opts)
+	}
+
+	opts, err = notifyRequest{Message: "Dinner", Locale: "ja", Devices: []string{"Kitchen", "Office"}, Volume: &volume, Priority: "high", Chime: googlecast.ChimeNone, Voice: "female", Wait: &wait}.options(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.FriendlyName != "" || !reflect.DeepEqual(opts.DeviceNames, []string{"Kitchen", "Office"}) {
+		t.Errorf("targets are not replaced: %q %q", opts.FriendlyName, opts.DeviceNames)
+	}
+	if opts.Locale != "ja" || opts.Voice != "female" || opts.Volume != 0.6 || opts.Priority != "high" || !opts.Wait {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+	if opts.Chimes != nil {
+		t.Errorf("chime is not disabled: %v", opts.Chimes)
+	}
+
+	if _, err := (notifyRequest{Message: "Dinner", Group: "upstairs"}).options(base); err == nil {
+		t.Error("unknown group is accepted")
+	}
+}
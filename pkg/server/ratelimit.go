@@ -0,0 +1,249 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
+)
+
+const (
+	// queueRetryAfter is a duration to retry after announcement queues are full
+	queueRetryAfter = 30 * time.Second
+	// maxIdleClients is a number of clients which triggers removing idle clients
+	maxIdleClients = 1024
+)
+
+var (
+	errRateLimited = errors.New("too many requests")
+	errDuplicated  = errors.New("the same message was sent recently")
+)
+
+type (
+	// RateLimit is limits of requests which make speakers talk. Zero values disable each limit.
+	RateLimit struct {
+		// Client limits requests of each API key, or each remote address without keys.
+		// Forwarded headers are not trusted, so all requests through a reverse proxy share a limit without keys.
+		Client Rate
+		// Global limits requests of all clients
+		Global Rate
+		// DuplicateWindow suppresses the same message to the same targets within the duration
+		DuplicateWindow time.Duration
+		// MaxQueue is a maximum number of waiting announcements on each device
+		MaxQueue int
+	}
+
+	// Rate is a number of requests per a duration. Requests up to the number are accepted in a burst.
+	Rate struct {
+		Requests int
+		Per      time.Duration
+	}
+
+	// bucket is a token bucket of a rate
+	bucket struct {
+		tokens float64
+		last   time.Time
+	}
+
+	// limiter rejects requests over rate limits, and duplicated messages
+	limiter struct {
+		conf RateLimit
+		now  func() time.Time
+
+		mu       sync.Mutex
+		global   *bucket
+		clients  map[string]*bucket
+		messages map[string]time.Time
+	}
+)
+
+func (r Rate) enabled() bool {
+	return r.Requests > 0 && r.Per > 0
+}
+
+func (r Rate) validate() error {
+	if r.Requests < 0 || r.Per < 0 || (r.Requests > 0) != (r.Per > 0) {
+		return fmt.Errorf("rate limit requires both positive requests and duration: %d per %s", r.Requests, r.Per)
+	}
+	return nil
+}
+
+func (c RateLimit) validate() error {
+	if err := c.Client.validate(); err != nil {
+		return err
+	}
+	if err := c.Global.validate(); err != nil {
+		return err
+	}
+	if c.DuplicateWindow < 0 || c.MaxQueue < 0 {
+		return errors.New("duplicate window and max queue must not be negative")
+	}
+	return nil
+}
+
+func newLimiter(conf RateLimit) *limiter {
+	return &limiter{conf: conf, now: time.Now, clients: map[string]*bucket{}, messages: map[string]time.Time{}}
+}
+
+// limit wraps the handler, which is only called within rate limits of the client and all clients
+func (l *limiter) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		client := clientOf(req)
+		if wait, ok := l.allow(client); !ok {
+			log.Printf("[WARN] reject %s %s from %s: rate limit", req.Method, req.URL.Path, client)
+			writeTooManyRequests(w, wait, errRateLimited)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// clientOf returns an authenticated key name, or a remote address of the request.
+// Requests through a reverse proxy or a Unix domain socket have the same remote address.
+func clientOf(req *http.Request) string {
+	if key, ok := req.Context().Value(keyContextKey{}).(Key); ok {
+		return "key:" + key.Name
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// allow takes tokens of the client and the global bucket.
+// It returns a duration until the request is allowed if any limit is exceeded.
+func (l *limiter) allow(client string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var buckets []*bucket
+	var rates []Rate
+	if l.conf.Client.enabled() {
+		b, ok := l.clients[client]
+		if !ok {
+			if len(l.clients) >= maxIdleClients {
+				l.removeIdleClients(now)
+			}
+			b = &bucket{tokens: float64(l.conf.Client.Requests), last: now}
+			l.clients[client] = b
+		}
+		buckets, rates = append(buckets, b), append(rates, l.conf.Client)
+	}
+	if l.conf.Global.enabled() {
+		if l.global == nil {
+			l.global = &bucket{tokens: float64(l.conf.Global.Requests), last: now}
+		}
+		buckets, rates = append(buckets, l.global), append(rates, l.conf.Global)
+	}
+	var wait time.Duration
+	for i, b := range buckets {
+		if w := b.refill(rates[i], now); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0, true
+}
+
+// removeIdleClients removes buckets which are full, since they are same as new buckets
+func (l *limiter) removeIdleClients(now time.Time) {
+	for client, b := range l.clients {
+		if b.refill(l.conf.Client, now); b.tokens >= float64(l.conf.Client.Requests) {
+			delete(l.clients, client)
+		}
+	}
+}
+
+// refill adds tokens since the last refill, and returns a duration until a token is available
+func (b *bucket) refill(rate Rate, now time.Time) time.Duration {
+	perToken := rate.Per / time.Duration(rate.Requests)
+	b.tokens = math.Min(float64(rate.Requests), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(perToken))
+}
+
+// duplicate records the message to the targets, and returns a duration until the message is accepted again
+// if the same message was recorded within the window. The returned key is passed to forget.
+func (l *limiter) duplicate(opts googlecast.Options, msg string) (key string, wait time.Duration, dup bool) {
+	if l.conf.DuplicateWindow <= 0 {
+		return "", 0, false
+	}
+	key = messageKey(opts, msg)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for k, at := range l.messages {
+		if now.Sub(at) >= l.conf.DuplicateWindow {
+			delete(l.messages, k)
+		}
+	}
+	if at, ok := l.messages[key]; ok {
+		return key, l.conf.DuplicateWindow - now.Sub(at), true
+	}
+	l.messages[key] = now
+	return key, 0, false
+}
+
+// forget removes a recorded message, so that failed notifications can be sent again
+func (l *limiter) forget(key string) {
+	if key == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.messages, key)
+}
+
+// messageKey returns a hash of a normalized message and targets
+func messageKey(opts googlecast.Options, msg string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(msg), " "))
+	fields := append([]string{normalized, opts.Locale, opts.FriendlyName, opts.Group, opts.CastGroup}, opts.DeviceNames...)
+	h := sha256.New()
+	for _, field := range fields {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// queueFull reports whether all devices rejected the notification by full queues
+func queueFull(result googlecast.Result) bool {
+	if len(result.Devices) == 0 {
+		return false
+	}
+	for _, device := range result.Devices {
+		if !errors.Is(device.Err, googlecast.ErrQueueFull) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeTooManyRequests writes status 429 with Retry-After in seconds
+func writeTooManyRequests(w http.ResponseWriter, wait time.Duration, err error) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeJSON(w, http.StatusTooManyRequests, errorResponse{Error: err.Error()})
+}
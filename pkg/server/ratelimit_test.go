@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestLimiterAllow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 1, 20, 13, 0, 0, 0, time.UTC)}
+	l := newLimiter(RateLimit{Client: Rate{Requests: 2, Per: time.Minute}, Global: Rate{Requests: 3, Per: time.Minute}})
+	l.now = clock.Now
+
+	for i := 0; i < 2; i++ {
+		if _, ok := l.allow("script"); !ok {
+			t.Fatalf("request %d of a burst is rejected", i)
+		}
+	}
+	wait, ok := l.allow("script")
+	if ok || wait != 30*time.Second {
+		t.Errorf("want rejection for 30s, got %v %s", ok, wait)
+	}
+	if _, ok := l.allow("phone"); !ok {
+		t.Error("another client is rejected")
+	}
+	// global limit is exceeded by the fourth request
+	if wait, ok := l.allow("tablet"); ok || wait != 20*time.Second {
+		t.Errorf("want global rejection for 20s, got %v %s", ok, wait)
+	}
+
+	clock.now = clock.now.Add(30 * time.Second)
+	if _, ok := l.allow("script"); !ok {
+		t.Error("refilled client is rejected")
+	}
+	// rejected requests do not take tokens
+	if _, ok := l.allow("tablet"); ok {
+		t.Error("global limit is not exceeded")
+	}
+}
+
+func TestLimiterDuplicate(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2021, 1, 20, 13, 0, 0, 0, time.UTC)}
+	l := newLimiter(RateLimit{DuplicateWindow: 5 * time.Minute})
+	l.now = clock.Now
+	kitchen := googlecast.Options{FriendlyName: "Kitchen", Locale: "en"}
+
+	key, _, dup := l.duplicate(kitchen, "Dinner is ready")
+	if dup {
+		t.Fatal("first message is duplicated")
+	}
+	if _, wait, dup := l.duplicate(kitchen, " dinner  IS ready"); !dup || wait != 5*time.Minute {
+		t.Errorf("want duplicate for 5m, got %v %s", dup, wait)
+	}
+	if _, _, dup := l.duplicate(googlecast.Options{FriendlyName: "Bedroom", Locale: "en"}, "Dinner is ready"); dup {
+		t.Error("message to other targets is duplicated")
+	}
+
+	l.forget(key)
+	if _, _, dup := l.duplicate(kitchen, "Dinner is ready"); dup {
+		t.Error("forgotten message is duplicated")
+	}
+	clock.now = clock.now.Add(5 * time.Minute)
+	if _, _, dup := l.duplicate(kitchen, "Dinner is ready"); dup {
+		t.Error("message after the window is duplicated")
+	}
+
+	if _, _, dup := newLimiter(RateLimit{}).duplicate(kitchen, "Dinner is ready"); dup {
+		t.Error("disabled window suppresses messages")
+	}
+}
+
+func TestLimit(t *testing.T) {
+	l := newLimiter(RateLimit{Client: Rate{Requests: 1, Per: time.Hour}})
+	auth := newAuthenticator([]Key{{Name: "script", Token: "token", Scopes: []Scope{ScopeNotify}}})
+	handler := auth.require(ScopeNotify, l.limit(func(w http.ResponseWriter, _ *http.Request) {}))
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/notify", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	if w := request("token"); w.Code != http.StatusOK {
+		t.Fatalf("first request is rejected: %d", w.Code)
+	}
+	w := request("token")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "3600" {
+		t.Errorf("want 429 with Retry-After 3600, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	// unauthenticated requests are rejected before rate limits
+	if w := request("guess"); w.Code != http.StatusUnauthorized {
+		t.Errorf("want 401, got %d", w.Code)
+	}
+}
+
+func TestRateLimitValidate(t *testing.T) {
+	tests := map[string]struct {
+		conf    RateLimit
+		wantErr bool
+	}{
+		"disabled":         {},
+		"limits":           {conf: RateLimit{Client: Rate{Requests: 5, Per: time.Minute}, Global: Rate{Requests: 20, Per: time.Minute}, DuplicateWindow: time.Minute, MaxQueue: 3}},
+		"no duration":      {conf: RateLimit{Client: Rate{Requests: 5}}, wantErr: true},
+		"no requests":      {conf: RateLimit{Global: Rate{Per: time.Minute}}, wantErr: true},
+		"negative queue":   {conf: RateLimit{MaxQueue: -1}, wantErr: true},
+		"negative request": {conf: RateLimit{Client: Rate{Requests: -1, Per: time.Minute}}, wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := tt.conf.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("want error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestWriteResultQueueFull(t *testing.T) {
+	tests := map[string]struct {
+		result googlecast.Result
+		want   int
+	}{
+		"all full": {result: googlecast.Result{Devices: []googlecast.DeviceResult{{Err: googlecast.ErrQueueFull}, {Err: googlecast.ErrQueueFull}}}, want: http.StatusTooManyRequests},
+		"partial":  {result: googlecast.Result{Devices: []googlecast.DeviceResult{{Err: googlecast.ErrQueueFull}, {}}}, want: http.StatusInternalServerError},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeResult(w, tt.result, tt.result.Err())
+			if w.Code != tt.want {
+				t.Errorf("want status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
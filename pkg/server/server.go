@@ -2,36 +2,120 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
+	"github.com/tomoyamachi/notifyhome/pkg/media"
 )
 
-func Run(ctx context.Context, deviceCnt int, deviceName, localeCode string, port int) error {
+// maxUploadSize is a maximum size of uploaded audio files
+const maxUploadSize = 32 << 20
+
+// Config is settings of the notification server
+type Config struct {
+	Port int
+	// Listen is a listen address such as "127.0.0.1:8000", or "unix:/path/to/socket" for a Unix domain socket.
+	// Empty listens on all interfaces on Port.
+	Listen string
+	TLS    TLSConfig
+	// Keys are API keys of clients. Requests are not authenticated if it is empty.
+	Keys []Key
+	// RateLimit limits requests of /notify and /play
+	RateLimit RateLimit
+}
+
+// Run runs a notification server. It also hosts media files if mediaServer is not nil.
+// Media files are not authenticated, since cast devices fetch them by random URLs.
+func Run(ctx context.Context, opts googlecast.Options, mediaServer *media.Server, conf Config) error {
+	if err := ValidateKeys(conf.Keys); err != nil {
+		return err
+	}
+	if err := conf.TLS.validate(); err != nil {
+		return err
+	}
+	if err := conf.RateLimit.validate(); err != nil {
+		return err
+	}
+	opts.MaxQueue = conf.RateLimit.MaxQueue
+	auth := newAuthenticator(conf.Keys)
+	limiter := newLimiter(conf.RateLimit)
 	handler := http.NewServeMux()
-	handler.HandleFunc("/quiet", makeQuiet)
-	handler.HandleFunc("/notify", func(w http.ResponseWriter, req *http.Request) {
+	if mediaServer != nil {
+		handler.Handle(media.Path, mediaServer)
+	}
+	handler.HandleFunc("/quiet", auth.require(ScopeQuiet, makeQuiet))
+	handler.HandleFunc("/queue", auth.require(ScopeAdmin, showQueues))
+	handler.HandleFunc("/devices", auth.require(ScopeAdmin, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeResponse(w, []byte("Invalid methods\n"))
+			return
+		}
+		// lists all devices, since the device count limits targets of notifications
+		writeJSON(w, http.StatusOK, googlecast.ListDevices(req.Context(), 0, req.URL.Query().Get("refresh") == "true"))
+	}))
+	handler.HandleFunc("/notify", auth.require(ScopeNotify, limiter.limit(func(w http.ResponseWriter, req *http.Request) {
 		if req.Method != http.MethodPost {
 			writeResponse(w, []byte("Invalid methods\n"))
 			return
 		}
+		reqOpts, err := requestOptions(opts, req.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeResponse(w, []byte(err.Error()+"\n"))
+			return
+		}
+		if isJSON(req) {
+			notifyJSON(ctx, w, req, reqOpts, limiter)
+			return
+		}
 		b, err := ioutil.ReadAll(req.Body)
 		if err != nil {
 			writeResponse(w, []byte("Internal error\n"))
 			return
 		}
-		if err := googlecast.Notify(ctx, deviceCnt, deviceName, localeCode, []string{string(b)}); err != nil {
-			log.Printf("notifyWithCtx %+v\n", err)
-			writeResponse(w, []byte("Internal error\n"))
+		notifyMessage(ctx, w, reqOpts, limiter, string(b))
+	})))
+	handler.HandleFunc("/play", auth.require(ScopeNotify, limiter.limit(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeResponse(w, []byte("Invalid methods\n"))
+			return
+		}
+		reqOpts, err := requestOptions(opts, req.URL.Query())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeResponse(w, []byte(err.Error()+"\n"))
+			return
+		}
+		audio, err := requestAudio(w, req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeResponse(w, []byte(err.Error()+"\n"))
 			return
 		}
-		writeResponse(w, b)
-	})
-	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler}
+		result, err := googlecast.PlayAudio(ctx, reqOpts, audio)
+		writeResult(w, result, err)
+	})))
+	server := &http.Server{Handler: handler}
+	if conf.TLS.enabled() {
+		tlsConf, err := conf.TLS.config()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = tlsConf
+	}
+	ln, err := conf.listen()
+	if err != nil {
+		return err
+	}
 	go func() {
 		<-ctx.Done()
 		log.Print("httpRun will be stop...")
@@ -39,11 +123,98 @@ func Run(ctx context.Context, deviceCnt int, deviceName, localeCode string, port
 			log.Printf("http server shutdown: %+v\n", err)
 		}
 	}()
-	log.Printf("server start on port: %d\n", port)
-	if err := server.ListenAndServe(); err != nil {
-		return err
+	if server.TLSConfig != nil {
+		log.Printf("server start on https: %s\n", ln.Addr())
+		// certificates are given by the TLS config
+		err = server.ServeTLS(ln, "", "")
+	} else {
+		log.Printf("server start on: %s\n", ln.Addr())
+		err = server.Serve(ln)
 	}
-	return nil
+	return err
+}
+
+// requestOptions returns options of a notification request. Targets of the request replace default targets.
+func requestOptions(opts googlecast.Options, query url.Values) (googlecast.Options, error) {
+	if query.Get("group") != "" || query.Get("device") != "" || query.Get("cast_group") != "" {
+		opts.Group = query.Get("group")
+		opts.FriendlyName = query.Get("device")
+		opts.CastGroup = query.Get("cast_group")
+		if _, ok := opts.Groups[opts.Group]; opts.Group != "" && !ok {
+			return opts, fmt.Errorf("unknown device group: %s", opts.Group)
+		}
+	}
+	if lang := query.Get("lang"); lang != "" {
+		if !localePattern.MatchString(lang) {
+			return opts, fmt.Errorf("invalid lang: %q", lang)
+		}
+		opts.Locale = lang
+	}
+	if priority := query.Get("priority"); priority != "" {
+		opts.Priority = priority
+	}
+	if wait := query.Get("wait"); wait != "" {
+		opts.Wait = wait == "true"
+	}
+	if v := query.Get("volume"); v != "" {
+		percent, err := strconv.Atoi(v)
+		if err != nil || percent < 1 || percent > 100 {
+			return opts, errors.New("volume must be between 1 and 100")
+		}
+		opts.Volume = float64(percent) / 100
+	}
+	return opts, nil
+}
+
+// notifyJSON notifies a message of a JSON request. Options of the request override query parameters.
+func notifyJSON(ctx context.Context, w http.ResponseWriter, req *http.Request, opts googlecast.Options, limiter *limiter) {
+	body, err := decodeNotifyRequest(http.MaxBytesReader(w, req.Body, maxNotifyRequestSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	if opts, err = body.options(opts); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	notifyMessage(ctx, w, opts, limiter, body.Message)
+}
+
+// notifyMessage notifies a message unless the same message was sent to the same targets recently
+func notifyMessage(ctx context.Context, w http.ResponseWriter, opts googlecast.Options, limiter *limiter, msg string) {
+	key, wait, dup := limiter.duplicate(opts, msg)
+	if dup {
+		log.Printf("[WARN] suppress a duplicated message: %q", msg)
+		writeTooManyRequests(w, wait, errDuplicated)
+		return
+	}
+	result, err := googlecast.Notify(ctx, opts, []string{msg})
+	if err != nil {
+		limiter.forget(key)
+	}
+	writeResult(w, result, err)
+}
+
+// requestAudio returns audio of an uploaded file field "file", or an URL of a parameter "url"
+func requestAudio(w http.ResponseWriter, req *http.Request) (*googlecast.Audio, error) {
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		req.Body = http.MaxBytesReader(w, req.Body, maxUploadSize)
+		f, header, err := req.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("read uploaded file: %w", err)
+		}
+		defer f.Close()
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, fmt.Errorf("read uploaded file: %w", err)
+		}
+		return &googlecast.Audio{Data: data, ContentType: googlecast.DetectContentType(header.Filename, data)}, nil
+	}
+	u, err := url.Parse(req.FormValue("url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.New("url must be an http(s) URL, or upload a file as multipart form")
+	}
+	return &googlecast.Audio{URL: u, ContentType: googlecast.DetectContentType(u.Path, nil)}, nil
 }
 
 func makeQuiet(w http.ResponseWriter, req *http.Request) {
@@ -56,6 +227,40 @@ func makeQuiet(w http.ResponseWriter, req *http.Request) {
 	writeResponse(w, []byte(fmt.Sprintf("I will be quiet until %s\n", targetTime.Format("2006/01/02 15:04"))))
 }
 
+func showQueues(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeResponse(w, []byte("Invalid methods\n"))
+		return
+	}
+	writeJSON(w, http.StatusOK, googlecast.Queues())
+}
+
+// writeResult writes a result of each device. Failed devices are reported in the result with status 500.
+func writeResult(w http.ResponseWriter, result googlecast.Result, err error) {
+	if err == nil {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
+	log.Printf("notifyWithCtx %+v\n", err)
+	if queueFull(result) {
+		writeTooManyRequests(w, queueRetryAfter, googlecast.ErrQueueFull)
+		return
+	}
+	if len(result.Devices) == 0 {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusInternalServerError, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write json to body %+v\n", err)
+	}
+}
+
 func writeResponse(w http.ResponseWriter, b []byte) {
 	if _, err := w.Write(b); err != nil {
 		log.Printf("write to body %+v\n", err)
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/tomoyamachi/notifyhome/pkg/googlecast"
+)
+
+func TestRequestOptions(t *testing.T) {
+	groups := map[string][]string{"downstairs": {"Living Room", "Kitchen"}}
+	base := googlecast.Options{FriendlyName: "Living Room", Locale: "en", Groups: groups}
+	tests := map[string]struct {
+		query   string
+		want    googlecast.Options
+		wantErr bool
+	}{
+		"default":     {query: "", want: base},
+		"lang":        {query: "lang=ja", want: googlecast.Options{FriendlyName: "Living Room", Locale: "ja"}},
+		"region lang": {query: "lang=en-GB", want: googlecast.Options{FriendlyName: "Living Room", Locale: "en-GB"}},
+		"bad lang":    {query: "lang=en%20GB", wantErr: true},
+		"device":      {query: "device=Kitchen&volume=50", want: googlecast.Options{FriendlyName: "Kitchen", Locale: "en", Volume: 0.5}},
+		"bad volume":  {query: "volume=loud", wantErr: true},
+		"zero volume": {query: "volume=0", wantErr: true},
+		"group":       {query: "group=downstairs", want: googlecast.Options{Group: "downstairs", Locale: "en"}},
+		"bad group":   {query: "group=upstairs", wantErr: true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			got, err := requestOptions(base, query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("want error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && (got.FriendlyName != tt.want.FriendlyName || got.Group != tt.want.Group || got.Locale != tt.want.Locale || got.Volume != tt.want.Volume) {
+				t.Errorf("want %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}